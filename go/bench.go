@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"time"
+)
+
+// startCPUProfile starts writing a CPU profile to path (-cpuprofile). The
+// returned stop function ends the profile and closes the file; it must run
+// before the process exits or the profile is incomplete.
+func startCPUProfile(path string) (stop func() error, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CPU profile '%s': %w", path, err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+	}
+	return func() error {
+		pprof.StopCPUProfile()
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write CPU profile '%s': %w", path, err)
+		}
+		return nil
+	}, nil
+}
+
+// printBench writes the result of a -bench run as one line in the Go
+// benchmark format, so runs can be compared with benchstat or split on
+// whitespace by a script, e.g.
+//
+//	BenchmarkRun/workers=8 100000 2512 ns/op 398089 tasks/s
+//
+// tasks is the number of tasks finished, ok or not, in elapsed. The format
+// has no room for "n/a", so a value that cannot be computed (no tasks, or
+// no measurable time; see perSecond) is printed as 0.
+func printBench(w io.Writer, workers int, tasks int64, elapsed time.Duration) error {
+	var nsPerTask float64
+	if tasks > 0 {
+		nsPerTask = float64(elapsed.Nanoseconds()) / float64(tasks)
+	}
+	rate, _ := perSecond(tasks, elapsed)
+	_, err := fmt.Fprintf(w, "BenchmarkRun/workers=%d %d %.0f ns/op %.0f tasks/s\n", workers, tasks, nsPerTask, rate)
+	return err
+}
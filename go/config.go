@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"dataproc/workerpool"
+)
+
+// Config holds run settings loaded from a -config file. Every field is
+// optional; a nil field leaves the flag default in place. Keys are the JSON
+// names below, e.g.
+//
+//	{"workers": 8, "tasks": 1000, "output": "target/run.txt",
+//	 "format": "json", "retries": 2, "rate": 50}
+//
+// Processor is not a flag: it holds the Processor's own options, passed
+// as is to its Init method (see workerpool.InitProcessor).
+type Config struct {
+	Workers *int     `json:"workers"`
+	Tasks   *int     `json:"tasks"`
+	Output  *string  `json:"output"`
+	Format  *string  `json:"format"`
+	Retries *int     `json:"retries"`
+	Rate    *float64 `json:"rate"`
+
+	Processor json.RawMessage `json:"processor"`
+}
+
+// loadConfig reads and validates the JSON config file at path. Unknown keys
+// are rejected so a typo does not silently fall back to a default.
+func loadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c Config
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: unexpected data after the config object", path)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// envConfig builds a Config from the PROC_WORKERS, PROC_TASKS, PROC_OUTPUT
+// and PROC_FORMAT environment variables; unset or empty variables stay nil.
+// The result is validated like a config file.
+func envConfig() (*Config, error) {
+	var c Config
+	atoi := func(name string) (*int, error) {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s=%q is not an integer", name, v)
+		}
+		return &n, nil
+	}
+	str := func(name string) *string {
+		if v := os.Getenv(name); v != "" {
+			return &v
+		}
+		return nil
+	}
+
+	var err error
+	if c.Workers, err = atoi("PROC_WORKERS"); err != nil {
+		return nil, err
+	}
+	if c.Tasks, err = atoi("PROC_TASKS"); err != nil {
+		return nil, err
+	}
+	c.Output = str("PROC_OUTPUT")
+	c.Format = str("PROC_FORMAT")
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+	return &c, nil
+}
+
+// validate checks the values that are set, with the same rules as the
+// corresponding flags.
+func (c *Config) validate() error {
+	if c.Workers != nil && *c.Workers <= 0 {
+		return fmt.Errorf("workers must be a positive integer, got %d", *c.Workers)
+	}
+	if c.Tasks != nil && *c.Tasks <= 0 {
+		return fmt.Errorf("tasks must be a positive integer, got %d", *c.Tasks)
+	}
+	if c.Output != nil && *c.Output == "" {
+		return errors.New("output must not be empty")
+	}
+	if c.Format != nil {
+		if _, err := workerpool.ParseFormats(*c.Format); err != nil {
+			return fmt.Errorf("format: %w", err)
+		}
+	}
+	if c.Retries != nil && *c.Retries < 0 {
+		return fmt.Errorf("retries must not be negative, got %d", *c.Retries)
+	}
+	if c.Rate != nil && *c.Rate < 0 {
+		return fmt.Errorf("rate must not be negative, got %g", *c.Rate)
+	}
+	return nil
+}
+
+// apply copies the configured values into their flags, skipping flags named
+// in explicit so values given on the command line win over the file.
+func (c *Config) apply(explicit map[string]bool) error {
+	set := func(name, value string) error {
+		if explicit[name] {
+			return nil
+		}
+		return flag.Set(name, value)
+	}
+
+	var errs []error
+	if c.Workers != nil {
+		errs = append(errs, set("workers", strconv.Itoa(*c.Workers)))
+	}
+	if c.Tasks != nil {
+		errs = append(errs, set("tasks", strconv.Itoa(*c.Tasks)))
+	}
+	if c.Output != nil {
+		errs = append(errs, set("out", *c.Output))
+	}
+	if c.Format != nil {
+		errs = append(errs, set("format", *c.Format))
+	}
+	if c.Retries != nil {
+		errs = append(errs, set("retries", strconv.Itoa(*c.Retries)))
+	}
+	if c.Rate != nil {
+		errs = append(errs, set("rate", strconv.FormatFloat(*c.Rate, 'g', -1, 64)))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,38 @@
+package main
+
+// dedupSet remembers the payloads the producer has already submitted so
+// duplicates can be skipped. It is only used by the producer goroutine, so
+// it needs no locking.
+//
+// Every distinct payload is kept in memory, which for large inputs costs
+// roughly the size of the input. With max > 0 the set holds at most max
+// payloads and forgets the oldest one first (FIFO), so a duplicate further
+// apart than max distinct payloads is processed again.
+type dedupSet struct {
+	max     int
+	seen    map[string]struct{}
+	order   []string // payloads in insertion order, for FIFO eviction
+	skipped int
+}
+
+func newDedupSet(max int) *dedupSet {
+	return &dedupSet{max: max, seen: make(map[string]struct{})}
+}
+
+// duplicate reports whether payload has been seen before, counting it as
+// skipped if so and remembering it otherwise.
+func (d *dedupSet) duplicate(payload string) bool {
+	if _, ok := d.seen[payload]; ok {
+		d.skipped++
+		return true
+	}
+	d.seen[payload] = struct{}{}
+	if d.max > 0 {
+		d.order = append(d.order, payload)
+		if len(d.order) > d.max {
+			delete(d.seen, d.order[0])
+			d.order = d.order[1:]
+		}
+	}
+	return false
+}
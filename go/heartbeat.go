@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"dataproc/workerpool"
+)
+
+// statser is the part of the pool the heartbeat reads.
+type statser interface {
+	Stats() workerpool.Stats
+}
+
+// heartbeat logs a line with the pool's queue depth, in-flight count and
+// totals every interval until stop is closed, then closes finished. The
+// ticker is stopped on return, so nothing outlives the run.
+//
+// A heartbeat with tasks queued or in flight but none finished since the
+// previous one is logged as a warning: the run is alive but not making
+// progress, e.g. because a downstream stalled or the pool deadlocked.
+func heartbeat(logger *slog.Logger, pool statser, interval time.Duration, stop <-chan struct{}, finished chan<- struct{}) {
+	defer close(finished)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last int64
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s := pool.Stats()
+		done := s.Completed + s.Failed
+		msg := fmt.Sprintf("Heartbeat: queue=%d in-flight=%d completed=%d failed=%d (+%d in %v)",
+			s.QueueDepth, s.InFlight, s.Completed, s.Failed, done-last, interval)
+		attrs := []any{"event", "HEARTBEAT", "queue", s.QueueDepth, "in_flight", s.InFlight, "completed", s.Completed, "failed", s.Failed}
+		if done == last && (s.QueueDepth > 0 || s.InFlight > 0) {
+			logger.Warn(msg+": no progress", attrs...)
+		} else {
+			logger.Info(msg, attrs...)
+		}
+		last = done
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode"
+
+	"dataproc/workerpool"
+)
+
+// maxLineSize is the longest input line (in bytes) a lineSource accepts.
+// bufio.Scanner defaults to 64KB and fails with ErrTooLong beyond that, which
+// is too small for payloads piped in from other tools.
+const maxLineSize = 1 << 20
+
+// streamQueueSize is the task queue buffer used when tasks are streamed from
+// -input files or with -stream. The producer runs concurrently with the
+// workers and blocks while the queue is full, so memory use is independent
+// of the number of tasks.
+const streamQueueSize = 256
+
+// TaskSource produces the tasks of a run, one at a time. Next returns the
+// next task and true, or false once the source is exhausted. A non-nil error
+// ends the source; tasks returned before it are still valid.
+//
+// The producer drains a TaskSource with drainTasks, so a new kind of input
+// only needs to implement Next and never touches the pool's channels.
+type TaskSource interface {
+	Next() (workerpool.StringTask, bool, error)
+}
+
+// generatorSource is the synthetic source: tasks offset+1..offset+n with
+// payloads "data-<ID>", i.e. "data-1".."data-n" without an offset.
+type generatorSource struct {
+	next, end int
+}
+
+func newGeneratorSource(n, offset int) *generatorSource {
+	return &generatorSource{next: offset, end: offset + n}
+}
+
+func (g *generatorSource) Next() (workerpool.StringTask, bool, error) {
+	if g.next >= g.end {
+		return workerpool.StringTask{}, false, nil
+	}
+	g.next++
+	return workerpool.StringTask{ID: g.next, Payload: fmt.Sprintf("data-%d", g.next)}, true, nil
+}
+
+// lineSource reads one Task per non-empty line. The 1-based line number
+// becomes the Task ID, so IDs stay stable and traceable back to the input
+// even when blank lines are skipped.
+//
+// Trailing "\r" and "\n" characters are trimmed so files authored on Windows
+// (CRLF line endings) produce the same payloads as Unix files. With meta set,
+// a key=value metadata prefix is split off each line into Task.Meta (see
+// splitMeta).
+type lineSource struct {
+	scanner *bufio.Scanner
+	lineNo  int
+	meta    bool
+}
+
+func newLineSource(r io.Reader) *lineSource {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return &lineSource{scanner: scanner}
+}
+
+func (s *lineSource) Next() (workerpool.StringTask, bool, error) {
+	for s.scanner.Scan() {
+		s.lineNo++
+
+		line := strings.TrimRight(s.scanner.Text(), "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		task := workerpool.StringTask{ID: s.lineNo, Payload: line}
+		if s.meta {
+			task.Meta, task.Payload = splitMeta(line)
+		}
+		return task, true, nil
+	}
+	return workerpool.StringTask{}, false, s.scanner.Err()
+}
+
+// splitMeta splits the metadata prefix off an input line: leading key=value
+// pairs, each ended by ";", as in "trace=abc;tenant=acme;payload". Parsing
+// stops at the first segment that is not such a pair, so the payload itself
+// may contain "=" and ";". Keys consist of letters, digits, "_", "-" and
+// ".". A line without a prefix has nil metadata.
+func splitMeta(line string) (map[string]string, string) {
+	var meta map[string]string
+	for {
+		pair, rest, ok := strings.Cut(line, ";")
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || !validMetaKey(key) {
+			break
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[key] = value
+		line = rest
+	}
+	return meta, line
+}
+
+func validMetaKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// gunzip wraps r, the input called name, in a gzip.Reader, so a lineSource
+// reads the decompressed lines. The gzip header is read here, so input that
+// is not gzip at all fails at once with a clear error; corruption further
+// in surfaces as a read error of the source.
+func gunzip(name string, r io.Reader) (io.Reader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a valid gzip file: %w", name, err)
+	}
+	return zr, nil
+}
+
+// namedSource is one -input file and the name used in its errors.
+type namedSource struct {
+	name string
+	src  *lineSource
+}
+
+// multiSource reads several line-based inputs one after the other, as if
+// they were concatenated: task IDs continue across file boundaries, so the
+// first line of the second file follows the last line of the first. offset
+// is added to every line number; set it up front to start past -id-offset.
+// A read error ends the source and names the file it came from.
+type multiSource struct {
+	inputs []namedSource
+	offset int
+}
+
+func (m *multiSource) Next() (workerpool.StringTask, bool, error) {
+	for len(m.inputs) > 0 {
+		in := m.inputs[0]
+		task, ok, err := in.src.Next()
+		if err != nil {
+			return workerpool.StringTask{}, false, fmt.Errorf("'%s': %w", in.name, err)
+		}
+		if ok {
+			task.ID += m.offset
+			return task, true, nil
+		}
+		m.offset += in.src.lineNo
+		m.inputs = m.inputs[1:]
+	}
+	return workerpool.StringTask{}, false, nil
+}
+
+// replaySource re-creates the tasks of a previous run from its JSON Lines
+// output (-replay): the id, payload and meta of every result become a Task
+// again. A failed task's payload is its original input, a successful one's
+// the processed output. A line that is not a JSON result is logged and
+// skipped, so one damaged line does not stop the replay.
+type replaySource struct {
+	name    string
+	lines   *lineSource
+	logger  *slog.Logger
+	skipped int
+}
+
+// replayResult is the part of a FormatJSON line that replaySource reads.
+type replayResult struct {
+	ID      *int              `json:"id"`
+	Payload *string           `json:"payload"`
+	Meta    map[string]string `json:"meta"`
+}
+
+func (s *replaySource) Next() (workerpool.StringTask, bool, error) {
+	for {
+		line, ok, err := s.lines.Next()
+		if err != nil {
+			return workerpool.StringTask{}, false, fmt.Errorf("'%s': %w", s.name, err)
+		}
+		if !ok {
+			return workerpool.StringTask{}, false, nil
+		}
+		var r replayResult
+		if err := json.Unmarshal([]byte(line.Payload), &r); err != nil || r.ID == nil || r.Payload == nil {
+			if err == nil {
+				err = errors.New("no id or payload field")
+			}
+			s.logger.Warn(fmt.Sprintf("Skipping line %d of '%s': not a JSON result: %v", line.ID, s.name, err),
+				"event", "REPLAY_SKIPPED")
+			s.skipped++
+			continue
+		}
+		return workerpool.StringTask{ID: *r.ID, Payload: *r.Payload, Meta: r.Meta}, true, nil
+	}
+}
+
+// jsonArraySource reads the tasks of -input-json: one JSON array of task
+// objects such as [{"id":1,"payload":"x","priority":2}, ...]. A json.Decoder
+// decodes one element at a time, so a huge array is never held in memory.
+// An element without an id gets its 1-based position in the array. Unknown
+// fields are errors, so a misspelt "priorty" is not silently ignored; every
+// error names the 0-based index of the offending element.
+type jsonArraySource struct {
+	name    string
+	dec     *json.Decoder
+	index   int
+	started bool
+	done    bool
+}
+
+// jsonTask is one element of an -input-json array.
+type jsonTask struct {
+	ID        *int              `json:"id"`
+	Payload   *string           `json:"payload"`
+	Priority  int               `json:"priority"`
+	Weight    int               `json:"weight"`
+	DependsOn []int             `json:"depends_on"`
+	Deadline  time.Time         `json:"deadline"`
+	Meta      map[string]string `json:"meta"`
+}
+
+func newJSONArraySource(name string, r io.Reader) *jsonArraySource {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	return &jsonArraySource{name: name, dec: dec}
+}
+
+func (s *jsonArraySource) Next() (workerpool.StringTask, bool, error) {
+	if s.done {
+		return workerpool.StringTask{}, false, nil
+	}
+	if !s.started {
+		s.started = true
+		tok, err := s.dec.Token()
+		if err == io.EOF {
+			err = errors.New("empty input, want a JSON array")
+		} else if err == nil && tok != json.Delim('[') {
+			err = fmt.Errorf("want a JSON array, found %v", tok)
+		}
+		if err != nil {
+			return s.fail(fmt.Errorf("'%s': %w", s.name, err))
+		}
+	}
+	if !s.dec.More() {
+		// The closing bracket, and nothing after it.
+		s.done = true
+		if _, err := s.dec.Token(); err != nil {
+			return s.fail(fmt.Errorf("'%s': after element [%d]: %w", s.name, s.index-1, err))
+		}
+		if _, err := s.dec.Token(); err != io.EOF {
+			return s.fail(fmt.Errorf("'%s': unexpected data after the array", s.name))
+		}
+		return workerpool.StringTask{}, false, nil
+	}
+
+	var t jsonTask
+	err := s.dec.Decode(&t)
+	if err == nil && t.Payload == nil {
+		err = errors.New("no payload field")
+	}
+	if err != nil {
+		return s.fail(fmt.Errorf("'%s': element [%d]: %w", s.name, s.index, err))
+	}
+	s.index++
+	return t.task(s.index), true, nil
+}
+
+// task returns the Task t describes, with ID defaultID if t has none.
+func (t jsonTask) task(defaultID int) workerpool.StringTask {
+	task := workerpool.StringTask{
+		ID:        defaultID,
+		Priority:  t.Priority,
+		Weight:    t.Weight,
+		DependsOn: t.DependsOn,
+		Deadline:  t.Deadline,
+		Meta:      t.Meta,
+	}
+	if t.ID != nil {
+		task.ID = *t.ID
+	}
+	if t.Payload != nil {
+		task.Payload = *t.Payload
+	}
+	return task
+}
+
+// fail ends the source with err: after a decode error the decoder cannot
+// find the next element reliably.
+func (s *jsonArraySource) fail(err error) (workerpool.StringTask, bool, error) {
+	s.done = true
+	return workerpool.StringTask{}, false, err
+}
+
+// inputList is the value of the -input flag. It accepts a comma-separated
+// list of files and may also be repeated; files are read in the order given.
+type inputList []string
+
+func (l *inputList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *inputList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			return errors.New("empty file name in list")
+		}
+		*l = append(*l, name)
+	}
+	return nil
+}
+
+// drainTasks passes every task from src to submit until src is exhausted or
+// fails. It checks ctx between submissions and stops early once it is
+// cancelled, returning ctx.Err(). It returns the number of tasks submitted.
+func drainTasks(ctx context.Context, src TaskSource, submit func(workerpool.StringTask)) (int, error) {
+	sent := 0
+	for {
+		task, ok, err := src.Next()
+		if err != nil || !ok {
+			return sent, err
+		}
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		submit(task)
+		sent++
+	}
+}
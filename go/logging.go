@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// newLogger builds the single logger shared by main and the worker pool.
+// "text" keeps the classic timestamped log lines; "json" emits one JSON
+// object per record, with the structured fields (worker, task, event, ...)
+// as keys, for log aggregators. Records below level are discarded.
+func newLogger(out io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	switch format {
+	case "text":
+		return slog.New(&plainHandler{out: log.New(out, "", log.LstdFlags), level: level}), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want \"text\" or \"json\")", format)
+}
+
+// plainHandler is a slog.Handler that renders records the way the program
+// always logged: a timestamp and the message, with "ERROR: "/"WARNING: "
+// prefixes. Attributes are dropped, since the messages already spell out the
+// worker and task they refer to.
+type plainHandler struct {
+	out   *log.Logger
+	level slog.Level
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	prefix := ""
+	switch {
+	case r.Level >= slog.LevelError:
+		prefix = "ERROR: "
+	case r.Level >= slog.LevelWarn:
+		prefix = "WARNING: "
+	}
+	return h.out.Output(0, prefix+r.Message)
+}
+
+func (h *plainHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *plainHandler) WithGroup(string) slog.Handler { return h }
@@ -1,163 +1,1157 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"math/rand"
+	"io"
+	"log/slog"
+	"net"
 	"os"
-	"sync"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime/pprof"
+	"strings"
+	"syscall"
+	"text/template"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"dataproc/workerpool"
 )
 
-// Task represents a single unit of work in the system.
-// Keeping it immutable-by-convention (no in-place mutation after creation)
-// reduces concurrency complexity and makes task handling predictable.
-type Task struct {
-	ID      int
-	Payload string
-}
+// handleSignals cancels the run on the first SIGINT/SIGTERM so the producer
+// stops feeding new tasks, workers stop picking up queued ones, and the writer
+// flushes what has completed. A second signal exits immediately for when
+// waiting is not an option.
+func handleSignals(logger *slog.Logger, cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
-// worker pulls tasks from the tasks channel, simulates processing, and sends
-// formatted results to resultsChan.
-//
-// Concurrency model (Go-idiomatic):
-// - Channels provide safe synchronization for task distribution.
-// - Workers terminate naturally when the tasks channel is closed and drained.
-// - No locks are required for task queue access because channels are concurrency-safe.
-//
-// Error handling:
-//   - In Go, errors are explicit return values. This worker function does not
-//     directly perform I/O, so it does not return an error. File I/O is handled
-//     centrally by a dedicated writer goroutine.
-func worker(workerID int, tasks <-chan Task, resultsChan chan<- string, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	// Local RNG per worker avoids global state and deprecation warnings
-	// related to rand.Seed in newer Go versions.
-	// This also avoids any contention between goroutines over shared RNG state.
-	r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(workerID)))
-
-	log.Printf("Worker-%d STARTED", workerID)
-
-	for task := range tasks {
-		log.Printf("Worker-%d Picked Task-%d", workerID, task.ID)
-
-		// Simulate compute delay (randomized to make concurrency visible in logs).
-		time.Sleep(time.Duration(r.Intn(300)+150) * time.Millisecond)
-
-		// Prepare output line (mirrors Java behavior for cross-language comparison).
-		resultLine := fmt.Sprintf("[%s] Worker-%d processed Task-%d payload='%s'\n",
-			time.Now().Format(time.RFC3339Nano),
-			workerID,
-			task.ID,
-			task.Payload,
-		)
-
-		// Send result to the writer goroutine. This separates compute from I/O,
-		// and avoids multiple goroutines writing to the file concurrently.
-		resultsChan <- resultLine
-
-		log.Printf("Worker-%d Completed Task-%d", workerID, task.ID)
-	}
-
-	log.Printf("Worker-%d FINISHED", workerID)
+	go func() {
+		sig := <-sigs
+		logger.Info(fmt.Sprintf("Received %v: shutting down gracefully (signal again to force exit)", sig), "event", "SHUTDOWN")
+		cancel()
+
+		sig = <-sigs
+		logger.Info(fmt.Sprintf("Received %v again: exiting immediately", sig), "event", "FORCE_EXIT")
+		os.Exit(1)
+	}()
 }
 
-// writer is the sole owner of the output file resource.
-// Only this goroutine writes to disk, which guarantees:
-// - no interleaved writes
-// - no need for mutex locks around file output
-//
-// Error handling:
-// - File creation/write/flush/close errors are logged.
-// - If file creation fails, we drain resultsChan to prevent worker deadlock.
-func writer(outputPath string, resultsChan <-chan string, done chan<- struct{}) {
-	defer close(done)
-
-	file, err := os.Create(outputPath)
+func main() {
+	// Parameters default to the Java values for direct comparison, but can be
+	// overridden from the command line without recompiling.
+	var (
+		numWorkers     int
+		numTasks       int
+		idOffset       int
+		stream         bool
+		dryRun         bool
+		bench          bool
+		benchDelay     bool
+		noDelay        bool
+		cpuProfile     string
+		templateText   string
+		heartbeatEvery time.Duration
+		watchdogAfter  time.Duration
+		watchdogAbort  bool
+		runDeadline    time.Duration
+		outputPath     string
+		sinkName       string
+		inputPaths     inputList
+		inputGzip      bool
+		inputMeta      bool
+		replayPath     string
+		inputJSON      string
+		fairWeights    bool
+		dependencies   bool
+		taskTimeout    time.Duration
+		escalation     float64
+		retries        int
+		ordered        bool
+		sortBatches    bool
+		formatName     string
+		verbose        bool
+		gzipOutput     bool
+		metricsAddr    string
+		progress       bool
+		quiet          bool
+		minWorkers     int
+		maxWorkers     int
+		maxConcurrent  int
+		rateLimit      float64
+		seed           int64
+		shardByName    string
+		dispatchName   string
+		encodingName   string
+		shards         int
+		batchSize      int
+		batchEvery     time.Duration
+		writeBuffer    int
+		rotateSize     byteSize
+		maxPayload     byteSize
+		maxInflight    byteSize
+		rotateEvery    time.Duration
+		appendOut      bool
+		summaryMode    string
+		logFormat      string
+		logLevel       string
+		dedup          bool
+		dedupMax       int
+		taskTTL        time.Duration
+		spillDir       string
+		maxPending     int
+		tlsCert        string
+		tlsKey         string
+		configPath     string
+		serveAddr      string
+		ckptPath       string
+		deadLetter     string
+		resultsBuf     int
+		localBuf       int
+		failFast       bool
+		breakerMax     int
+		breakerWait    time.Duration
+		filterExpr     string
+		transformsSpec string
+		idleTimeout    time.Duration
+		manifestOut    string
+		otelEndpoint   string
+	)
+	flag.StringVar(&configPath, "config", "", "load workers, tasks, out, format, retries and rate from JSON `FILE`; flags given explicitly win")
+	flag.IntVar(&numWorkers, "workers", 4, "number of worker goroutines (must be > 0)")
+	flag.IntVar(&minWorkers, "min-workers", 1, "with -max-workers: number of workers to start with")
+	flag.IntVar(&maxConcurrent, "max-concurrency", 0, "process at most this many tasks at once across all workers, e.g. a downstream connection limit (0 means one per worker)")
+	flag.IntVar(&maxWorkers, "max-workers", 0, "enable autoscaling up to this many workers while the queue is deep (0 disables; -workers is then ignored)")
+	flag.IntVar(&numTasks, "tasks", 20, "number of tasks to generate (must be > 0)")
+	flag.IntVar(&idOffset, "id-offset", 0, "add `N` to every task ID (generated, input line number or -serve), e.g. 1000 to start at Task-1001, so IDs stay unique across batches")
+	flag.BoolVar(&bench, "bench", false, "benchmark the pool: run the generated -tasks without the simulated delay and print the duration and tasks/s to stdout in Go benchmark format")
+	flag.BoolVar(&noDelay, "no-delay", false, "skip the simulated 150-450ms processing delay, so every task is processed at once; the rest of the pipeline is unchanged (for tests and scripts)")
+	flag.BoolVar(&benchDelay, "bench-delay", false, "keep the simulated 150-450ms processing delay in -bench")
+	flag.DurationVar(&runDeadline, "deadline", 0, "stop the whole run after `DURATION`, e.g. 5m: unfinished tasks are abandoned and completed results written (0 disables)")
+	flag.DurationVar(&watchdogAfter, "watchdog", 0, "log an error with a diagnostic dump when no task finished for `DURATION`, e.g. 60s, while tasks are queued or in flight (0 disables)")
+	flag.BoolVar(&watchdogAbort, "watchdog-abort", false, "when the -watchdog trips, also dump every goroutine's stack to stderr and exit with status 1")
+	flag.DurationVar(&heartbeatEvery, "heartbeat", 0, "log the queue depth and in-flight count every `INTERVAL`, e.g. 10s, warning when nothing finished since the last beat (0 disables)")
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "write a CPU profile of the run to `FILE`, e.g. cpu.pprof (inspect with go tool pprof)")
+	flag.BoolVar(&dryRun, "dry-run", false, "read, count and filter the tasks and report what would be done, without processing anything or creating output files")
+	flag.BoolVar(&stream, "stream", false, fmt.Sprintf("generate tasks while the workers run through a %d-slot queue instead of queueing all -tasks up front, so memory stays constant (always on with -input)", streamQueueSize))
+	flag.StringVar(&outputPath, "out", "target/go-output.txt", "path of the output file (\"-\" for stdout), or tcp://HOST:PORT to stream result lines to a collector")
+	flag.StringVar(&sinkName, "sink", "out", "send results to `SINK`: out (the -out destination), log (as log records) or discard")
+	flag.DurationVar(&taskTimeout, "task-timeout", 0, "maximum processing time per task, e.g. 2s (0 or negative disables)")
+	flag.StringVar(&encodingName, "payload-encoding", "none", "decode each payload before processing: none or base64-gzip (gzip, then base64); undecodable payloads go to the -dead-letter file")
+	flag.StringVar(&dispatchName, "dispatch", "shared", "how tasks reach workers: shared (free workers take the next task) or round-robin (a dispatcher hands them out in turn, skipping busy workers)")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 0, "let workers exit after this long without a task, e.g. 30s; replacements start when tasks arrive (0 disables)")
+	flag.DurationVar(&taskTTL, "task-ttl", 0, "drop tasks still queued this long after submission, e.g. 5s (0 disables)")
+	flag.BoolVar(&failFast, "fail-fast", false, "stop the run at the first task that fails after all retries")
+	flag.IntVar(&breakerMax, "breaker-threshold", 0, "open a circuit breaker after `N` consecutive failed attempts, failing tasks fast (to the dead-letter file) instead of processing them (0 disables)")
+	flag.DurationVar(&breakerWait, "breaker-cooldown", 30*time.Second, "how long an open circuit breaker fails tasks fast before letting one probe task through")
+	flag.Float64Var(&escalation, "timeout-escalation", 1, "multiply -task-timeout by `F` for each retry after a timeout, e.g. 2 (1 disables)")
+	flag.IntVar(&retries, "retries", 3, "number of times a failed task is retried with exponential backoff")
+	flag.StringVar(&templateText, "template", "", "render each output line with this Go text/template instead of -format, e.g. '{{.WorkerID}},{{.TaskID}},{{.Payload}}' (fields: TaskID, WorkerID, Payload, Timestamp, Err, Attempts, Duration, Meta)")
+	flag.StringVar(&formatName, "format", "text", "output format: text, json (one JSON object per line) or csv (with a header row); a comma-separated list such as text,json writes one file per format")
+	flag.BoolVar(&verbose, "verbose", false, "add each task's attempt count and processing duration to the output (attempts=N duration=D in text, attempts/duration_ms in json and csv)")
+	flag.Float64Var(&rateLimit, "rate", 0, "maximum tasks per second across all workers (0 means unlimited)")
+	flag.BoolVar(&gzipOutput, "gzip", false, "gzip-compress the output file (\".gz\" is appended to -out)")
+	flag.BoolVar(&appendOut, "append", false, "append to the output file instead of truncating it (not with -gzip)")
+	flag.StringVar(&shardByName, "shard-by", "none", "split output across files: none or worker (one file per worker)")
+	flag.IntVar(&shards, "shards", 1, "hash-partition output across `N` files by task ID (taskID % N)")
+	flag.IntVar(&resultsBuf, "results-buffer", 1000, "buffer `N` results between workers and the writer; workers block when it is full")
+	flag.IntVar(&localBuf, "local-buffer", 0, "let each worker collect up to `N` results and send them to the writer as one batch, for less channel contention at high task rates (0 or 1 disables)")
+	flag.IntVar(&batchSize, "batch-size", 100, "write and flush output in batches of up to `N` lines (0 disables batching)")
+	flag.DurationVar(&batchEvery, "batch-interval", 500*time.Millisecond, "flush a partial output batch after this long")
+	flag.IntVar(&writeBuffer, "write-buffer", 4096, "size in `BYTES` of the output write buffer (at least 512, else the default is used)")
+	flag.Var(&maxInflight, "max-inflight-bytes", "hold back the producer while the payloads of queued and unfinished tasks add up to more than `SIZE` bytes, e.g. 64MB (0 disables)")
+	flag.Var(&maxPayload, "max-payload", "refuse tasks whose payload is longer than `SIZE` bytes, e.g. 4096 or 4KB, sending them to the -dead-letter file instead of a worker (0 disables)")
+	flag.Var(&rotateSize, "rotate-size", "start a new numbered output file (go-output.1.txt, .2.txt, ...) once the current one holds `SIZE` bytes, e.g. 10MB (0 disables)")
+	flag.DurationVar(&rotateEvery, "rotate-interval", 0, "start a new output file named after the UTC start of each interval, e.g. 1h for go-output.20261015T140000Z.txt (at least 1s; 0 disables)")
+	flag.BoolVar(&ordered, "ordered", false, "write results in ascending task ID order instead of completion order")
+	flag.BoolVar(&sortBatches, "sort-batches", false, "sort each output batch (see -batch-size) by task ID: ordered within a batch, without the memory cost of -ordered")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on `ADDR` (e.g. :9090); disabled when empty")
+	flag.StringVar(&otelEndpoint, "otel-endpoint", "", "export OpenTelemetry trace spans (one per task, under one per run) over OTLP/HTTP to `URL`, e.g. http://localhost:4318; disabled when empty")
+	flag.BoolVar(&quiet, "quiet", false, "log nothing but errors, leaving stderr to them and the -summary (same as -log-level=error)")
+	flag.BoolVar(&progress, "progress", false, "print a progress line to stderr every second")
+	flag.StringVar(&summaryMode, "summary", "text", "end-of-run summary on stderr: text, json or none")
+	flag.StringVar(&logFormat, "log-format", "text", "log format: text (human-readable) or json (structured, via log/slog)")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum log level: debug, info, warn or error (per-task lines are debug)")
+	flag.Int64Var(&seed, "seed", 0, "seed worker RNGs with `N` + worker ID for reproducible runs (default: clock-based)")
+	flag.BoolVar(&dedup, "dedup", false, "skip tasks whose payload has already been submitted")
+	flag.IntVar(&dedupMax, "dedup-max", 0, "with -dedup: remember at most `N` payloads, forgetting the oldest first (0 means unlimited)")
+	flag.StringVar(&transformsSpec, "transforms", "", "rewrite each payload before it is filtered and submitted, in order: comma-separated `LIST` of trim, upper, lower and prefix:TEXT")
+	flag.StringVar(&filterExpr, "filter", "", "only process tasks whose payload matches the regular expression `REGEX`")
+	flag.StringVar(&spillDir, "spill-dir", "", "with -serve: spill tasks that find the queue full to a file in `DIR` instead of rejecting them, and feed them back as room frees up")
+	flag.IntVar(&maxPending, "max-pending", 10000, "with -serve: answer 429 to submissions while `N` accepted tasks are still unfinished (0 means no cap)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "with -serve: serve HTTPS with the PEM certificate (chain) in `FILE`; needs -tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "with -serve: the PEM private key in `FILE` for -tls-cert")
+	flag.StringVar(&serveAddr, "serve", "", "run as a service accepting tasks via POST /tasks on `ADDR` (e.g. :8080) until interrupted, with GET /healthz and /readyz probes; -tasks sets the queue size")
+	flag.StringVar(&manifestOut, "manifest", "", "after the run, write a JSON manifest (inputs, workers, times, task counts, output files with SHA-256) to `FILE`, e.g. target/manifest.json")
+	flag.StringVar(&deadLetter, "dead-letter", "", "write tasks that fail after all retries to `FILE` as JSON lines (id, payload, attempts, error)")
+	flag.StringVar(&ckptPath, "checkpoint", "", "record completed task IDs in `FILE` and skip them when re-run with the same file (use with -append)")
+	flag.BoolVar(&inputMeta, "input-meta", false, "split a metadata prefix of key=value pairs, each ended by ';', off every -input line, e.g. 'trace=abc;tenant=acme;payload', and carry it into the output (json, or -verbose text and csv)")
+	flag.BoolVar(&inputGzip, "input-gzip", false, "decompress every -input or -input-json (including stdin) with gzip; files ending in .gz always are")
+	flag.StringVar(&replayPath, "replay", "", "re-run the tasks recorded in a previous -format=json output `FILE` (id, payload and meta of each line; .gz is decompressed), skipping malformed lines with a warning")
+	flag.StringVar(&inputJSON, "input-json", "", "read tasks from `FILE` holding one JSON array of task objects, e.g. [{\"id\":1,\"payload\":\"x\",\"priority\":2}] (fields: id, payload, priority, weight, depends_on, deadline, meta; \"-\" for stdin, .gz is decompressed); priorities take effect, weight with -fair and depends_on with -dependencies")
+	flag.BoolVar(&fairWeights, "fair", false, "with -input-json: hand tasks to workers by weighted fair queuing on their weight instead of first in, first out")
+	flag.BoolVar(&dependencies, "dependencies", false, "with -input-json: start a task only once every task in its depends_on has succeeded; tasks are held until the whole file is read, and unknown dependencies or cycles fail the run")
+	flag.Var(&inputPaths, "input", "read tasks from `FILE` (one per non-empty line, \"-\" for stdin) instead of generating them; takes a comma-separated list or may be repeated")
+	flag.Parse()
+
+	// Note which flags were given explicitly: they override PROC_* variables
+	// and -config, and -seed is only applied when given, since 0 is a valid
+	// seed. Precedence is flags > environment > config file > defaults.
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	seedSet := explicit["seed"]
+
+	// processorOpts are the -config file's "processor" options, handed to
+	// the Processor's Init once it is built.
+	var processorOpts json.RawMessage
+	if configPath != "" {
+		cfg, err := loadConfig(configPath)
+		if err == nil {
+			err = cfg.apply(explicit)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: -config: %v\n", err)
+			os.Exit(2)
+		}
+		processorOpts = cfg.Processor
+	}
+	env, err := envConfig()
+	if err == nil {
+		err = env.apply(explicit)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(2)
+	}
+
+	// All logging, including the pool's, goes through this one logger.
+	// -quiet keeps only errors, which still explain a non-zero exit status.
+	if quiet {
+		if explicit["log-level"] || progress {
+			fmt.Fprintln(os.Stderr, "ERROR: -quiet cannot be combined with -log-level or -progress")
+			flag.Usage()
+			os.Exit(2)
+		}
+		logLevel = "error"
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -log-level: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	logger, err := newLogger(os.Stderr, logFormat, level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -log-format: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if numWorkers <= 0 || numTasks <= 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -workers and -tasks must be positive integers")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if runDeadline < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -deadline must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if heartbeatEvery < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -heartbeat must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if watchdogAfter < 0 || (watchdogAbort && watchdogAfter == 0) {
+		fmt.Fprintln(os.Stderr, "ERROR: -watchdog must not be negative, and -watchdog-abort needs a -watchdog duration")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if maxConcurrent < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -max-concurrency must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if idOffset < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -id-offset must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if maxWorkers > 0 {
+		if minWorkers <= 0 || maxWorkers < minWorkers {
+			fmt.Fprintln(os.Stderr, "ERROR: autoscaling needs 0 < -min-workers <= -max-workers")
+			flag.Usage()
+			os.Exit(2)
+		}
+		numWorkers = minWorkers
+	}
+	if retries < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -retries must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if escalation < 1 {
+		fmt.Fprintln(os.Stderr, "ERROR: -timeout-escalation must be at least 1")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if escalation > 1 && (taskTimeout <= 0 || retries == 0) {
+		fmt.Fprintln(os.Stderr, "ERROR: -timeout-escalation requires -task-timeout and -retries")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if breakerMax < 0 || (breakerMax > 0 && breakerWait <= 0) {
+		fmt.Fprintln(os.Stderr, "ERROR: -breaker-threshold must not be negative, and -breaker-cooldown must be positive")
+		flag.Usage()
+		os.Exit(2)
+	}
+	formats, err := workerpool.ParseFormats(formatName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -format: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if inputMeta && len(inputPaths) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -input-meta requires -input")
+		flag.Usage()
+		os.Exit(2)
+	}
+	var lineTemplate *template.Template
+	if templateText != "" {
+		if len(formats) > 1 || formats[0] != workerpool.FormatText || verbose {
+			fmt.Fprintln(os.Stderr, "ERROR: -template replaces -format and -verbose; they cannot be combined")
+			flag.Usage()
+			os.Exit(2)
+		}
+		if lineTemplate, err = workerpool.ParseTemplate(templateText); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: -template: %v\n", err)
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	payloadEnc, err := workerpool.ParsePayloadEncoding(encodingName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -payload-encoding: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	newSink, err := parseSink(sinkName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -sink: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if newSink != nil {
+		// These only shape the lines and files of the -out destination.
+		for _, name := range []string{"out", "format", "template", "verbose", "gzip", "append", "shard-by", "shards", "batch-size", "sort-batches", "write-buffer", "rotate-size", "rotate-interval"} {
+			if explicit[name] {
+				fmt.Fprintf(os.Stderr, "ERROR: -sink=%s cannot be combined with -%s\n", sinkName, name)
+				flag.Usage()
+				os.Exit(2)
+			}
+		}
+	}
+	dispatch, err := workerpool.ParseDispatch(dispatchName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -dispatch: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if dispatch == workerpool.DispatchRoundRobin && (maxWorkers > 0 || idleTimeout > 0) {
+		fmt.Fprintln(os.Stderr, "ERROR: -dispatch=round-robin needs a fixed set of workers; it cannot be combined with -max-workers or -idle-timeout")
+		flag.Usage()
+		os.Exit(2)
+	}
+	shardBy, err := workerpool.ParseShardBy(shardByName)
 	if err != nil {
-		log.Printf("ERROR: failed to create output file '%s': %v", outputPath, err)
+		fmt.Fprintf(os.Stderr, "ERROR: -shard-by: %v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if shards <= 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -shards must be a positive integer")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if shards > 1 && shardBy != workerpool.ShardNone {
+		fmt.Fprintln(os.Stderr, "ERROR: -shards and -shard-by cannot be combined")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(formats) > 1 && (outputPath == "-" || shards > 1 || shardBy != workerpool.ShardNone) {
+		fmt.Fprintln(os.Stderr, "ERROR: several -format values cannot be combined with -out=- or sharded output")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if (shards > 1 || shardBy != workerpool.ShardNone) && (outputPath == "-" || ordered) {
+		fmt.Fprintln(os.Stderr, "ERROR: sharded output cannot be combined with -out=- or -ordered")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if sortBatches && (batchSize <= 0 || ordered) {
+		fmt.Fprintln(os.Stderr, "ERROR: -sort-batches needs -batch-size > 0 and cannot be combined with -ordered")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if summaryMode != "text" && summaryMode != "json" && summaryMode != "none" {
+		fmt.Fprintf(os.Stderr, "ERROR: -summary: unknown mode %q (want \"text\", \"json\" or \"none\")\n", summaryMode)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if resultsBuf < 0 || localBuf < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -results-buffer and -local-buffer must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if dedupMax < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -dedup-max must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if noDelay && benchDelay {
+		fmt.Fprintln(os.Stderr, "ERROR: -no-delay and -bench-delay cannot be combined")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if bench && (serveAddr != "" || len(inputPaths) > 0 || dryRun || outputPath == "-") {
+		fmt.Fprintln(os.Stderr, "ERROR: -bench cannot be combined with -serve, -input, -dry-run or -out=-")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if replayPath != "" && (len(inputPaths) > 0 || serveAddr != "" || bench || idOffset > 0) {
+		fmt.Fprintln(os.Stderr, "ERROR: -replay cannot be combined with -input, -serve, -bench or -id-offset")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if inputJSON != "" && (len(inputPaths) > 0 || replayPath != "" || serveAddr != "" || bench || idOffset > 0) {
+		fmt.Fprintln(os.Stderr, "ERROR: -input-json cannot be combined with -input, -replay, -serve, -bench or -id-offset")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if (fairWeights || dependencies) && inputJSON == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -fair and -dependencies need -input-json, the only source with weights and dependencies")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		fmt.Fprintln(os.Stderr, "ERROR: -tls-cert and -tls-key must be given together")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if tlsCert != "" && serveAddr == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -tls-cert and -tls-key require -serve")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if spillDir != "" && serveAddr == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -spill-dir requires -serve")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if maxPending < 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: -max-pending must not be negative")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if explicit["max-pending"] && serveAddr == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -max-pending requires -serve")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if serveAddr != "" && (len(inputPaths) > 0 || dedup || ckptPath != "" || filterExpr != "" || transformsSpec != "" || dryRun) {
+		fmt.Fprintln(os.Stderr, "ERROR: -serve cannot be combined with -input, -dedup, -checkpoint, -filter, -transforms or -dry-run")
+		flag.Usage()
+		os.Exit(2)
+	}
+	var filter *regexp.Regexp
+	if filterExpr != "" {
+		if filter, err = regexp.Compile(filterExpr); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: -filter: invalid regular expression: %v\n", err)
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	if (rotateSize > 0 || rotateEvery > 0) && outputPath == "-" {
+		fmt.Fprintln(os.Stderr, "ERROR: -rotate-size and -rotate-interval cannot be combined with -out=-")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if rotateEvery > 0 && rotateEvery < time.Second {
+		// File names have a resolution of one second.
+		fmt.Fprintln(os.Stderr, "ERROR: -rotate-interval must be at least 1s")
+		flag.Usage()
+		os.Exit(2)
+	}
+	var pipeline []transform
+	if transformsSpec != "" {
+		if pipeline, err = parseTransforms(transformsSpec); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: -transforms: %v\n", err)
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	// A tcp:// output is one plain stream of lines on a connection.
+	if addr, ok := strings.CutPrefix(outputPath, "tcp://"); ok {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: -out: invalid TCP address %q: %v\n", addr, err)
+			flag.Usage()
+			os.Exit(2)
+		}
+		if gzipOutput || appendOut || rotateSize > 0 || rotateEvery > 0 || shards > 1 || shardBy != workerpool.ShardNone || len(formats) > 1 {
+			fmt.Fprintln(os.Stderr, "ERROR: -out=tcp://... cannot be combined with -gzip, -append, -rotate-size, -rotate-interval, sharded output or several -format values")
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+	if appendOut && gzipOutput {
+		fmt.Fprintln(os.Stderr, "ERROR: -append cannot be combined with -gzip")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if gzipOutput && outputPath != "-" && !strings.HasSuffix(outputPath, ".gz") {
+		outputPath += ".gz"
+	}
+	if writeBuffer < 512 {
+		logger.Warn(fmt.Sprintf("-write-buffer=%d is below the 512-byte minimum; using the default buffer size", writeBuffer))
+	}
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: unexpected arguments: %v\n", flag.Args())
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Build the processor now, so options it rejects stop the program
+	// before any input is read or any task runs.
+	proc := workerpool.NewSimulatedProcessor()
+	if seedSet {
+		proc = workerpool.NewSeededSimulatedProcessor(seed)
+	}
+	if noDelay || (bench && !benchDelay) {
+		proc.DisableDelay()
+	}
+	if err := workerpool.InitProcessor(proc, processorOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: -config: %s: processor: %v\n", configPath, err)
+		os.Exit(2)
+	}
+
+	// Select the task source: the synthetic generator by default, the
+	// -input files read one after another, the -input-json file or the
+	// -replay file. They are all opened up front so a bad path fails fast,
+	// before any goroutines are started or the output file is truncated.
+	// "-" reads from standard input, e.g. `cat jobs.txt | ./dataproc -input=-`.
+	var source TaskSource = newGeneratorSource(numTasks, idOffset)
+	inputNames := make([]string, len(inputPaths))
+	for i, path := range inputPaths {
+		inputNames[i] = path
+		if path == "-" {
+			inputNames[i] = "<stdin>"
+		}
+	}
+	if len(inputPaths) > 0 {
+		multi := &multiSource{offset: idOffset}
+		for i, path := range inputPaths {
+			var r io.Reader = os.Stdin
+			if path != "-" {
+				f, err := os.Open(path)
+				if err != nil {
+					logger.Error(fmt.Sprintf("failed to open input file '%s': %v", path, err))
+					os.Exit(1)
+				}
+				defer f.Close()
+				r = f
+			}
+			// Compressed inputs are recognized by their extension, or all
+			// inputs (stdin included) are with -input-gzip.
+			if inputGzip || strings.HasSuffix(path, ".gz") {
+				if r, err = gunzip(inputNames[i], r); err != nil {
+					logger.Error(err.Error())
+					os.Exit(1)
+				}
+			}
+			src := newLineSource(r)
+			src.meta = inputMeta
+			multi.inputs = append(multi.inputs, namedSource{name: inputNames[i], src: src})
+		}
+		source = multi
+	}
+	var replay *replaySource
+	if replayPath != "" {
+		f, err := os.Open(replayPath)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to open replay file '%s': %v", replayPath, err))
+			os.Exit(1)
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if strings.HasSuffix(replayPath, ".gz") {
+			if r, err = gunzip(replayPath, r); err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+		replay = &replaySource{name: replayPath, lines: newLineSource(r), logger: logger}
+		source = replay
+		inputNames = []string{replayPath}
+	}
+	if inputJSON != "" {
+		name := inputJSON
+		var r io.Reader = os.Stdin
+		if inputJSON == "-" {
+			name = "<stdin>"
+		} else {
+			f, err := os.Open(inputJSON)
+			if err != nil {
+				logger.Error(fmt.Sprintf("failed to open input file '%s': %v", inputJSON, err))
+				os.Exit(1)
+			}
+			defer f.Close()
+			r = f
+		}
+		if inputGzip || strings.HasSuffix(inputJSON, ".gz") {
+			if r, err = gunzip(name, r); err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+		source = newJSONArraySource(name, r)
+		inputNames = []string{name}
+	}
+
+	// Only the synthetic generator knows its task count up front. Workers
+	// beyond it would start, find the queue closed and exit without a task,
+	// so they are not started at all. Input files and -serve keep the full
+	// count, as any number of tasks may still come.
+	if len(inputNames) == 0 && serveAddr == "" && max(numWorkers, maxWorkers) > numTasks {
+		logger.Info(fmt.Sprintf("Only %d task(s): capping workers at %d (of %d requested)", numTasks, numTasks, max(numWorkers, maxWorkers)),
+			"event", "WORKERS_CAPPED")
+		numWorkers = min(numWorkers, numTasks)
+		if maxWorkers = min(maxWorkers, numTasks); maxWorkers <= numWorkers {
+			// Nothing is left to scale up to.
+			maxWorkers = 0
+		}
+	}
+
+	// ctx is cancelled on SIGINT/SIGTERM. It is shared by the producer,
+	// workers and writer so a single cancel stops the whole pipeline.
+	// With -deadline it also expires once the run has taken that long, which
+	// stops the run just like a signal.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if runDeadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, runDeadline)
+		defer cancelDeadline()
+	}
+	handleSignals(logger, cancel)
+
+	// Buffering the queue to numTasks allows the synthetic producer to
+	// enqueue all tasks without blocking. In -serve mode it bounds how many
+	// submitted tasks may wait before requests are rejected. Input files and
+	// -stream use a small fixed queue instead: the producer blocks on it
+	// while the workers catch up, so memory does not grow with the number of
+	// tasks.
+	queueSize := numTasks
+	if (stream || len(inputNames) > 0) && serveAddr == "" {
+		queueSize = streamQueueSize
+	}
+	sink := outputSink(outputPath)
+	if newSink != nil {
+		sink = newSink(logger)
+	}
+	opts := []workerpool.Option{
+		workerpool.WithSink(sink),
+		workerpool.WithTaskTimeout(taskTimeout),
+		workerpool.WithTimeoutEscalation(escalation),
+		workerpool.WithRetries(retries),
+		workerpool.WithFormats(formats...),
+		workerpool.WithTemplate(lineTemplate),
+		workerpool.WithVerbose(verbose),
+		workerpool.WithGzip(gzipOutput),
+		workerpool.WithAppend(appendOut),
+		workerpool.WithOrdered(ordered),
+		workerpool.WithShardBy(shardBy),
+		workerpool.WithDispatch(dispatch),
+		workerpool.WithShards(shards),
+		workerpool.WithBatching(batchSize, batchEvery),
+		workerpool.WithSortedBatches(sortBatches),
+		// Only JSON input can carry priorities, weights and dependencies.
+		workerpool.WithPriorityQueue(inputJSON != ""),
+		workerpool.WithFairScheduling(fairWeights),
+		workerpool.WithDependencies(dependencies),
+		workerpool.WithWriteBuffer(writeBuffer),
+		workerpool.WithRotateSize(int64(rotateSize)),
+		workerpool.WithRotateInterval(rotateEvery),
+		workerpool.WithQueueSize(queueSize),
+		workerpool.WithResultsBuffer(resultsBuf),
+		workerpool.WithLocalBuffer(localBuf),
+		workerpool.WithFailFast(failFast),
+		workerpool.WithCircuitBreaker(breakerMax, breakerWait),
+		workerpool.WithIdleTimeout(idleTimeout),
+		workerpool.WithMaxWorkers(maxWorkers),
+		workerpool.WithMaxConcurrency(maxConcurrent),
+		workerpool.WithMaxInflightBytes(int64(maxInflight)),
+		workerpool.WithRateLimit(rateLimit),
+		workerpool.WithLogger(logger),
+	}
+	if deadLetter != "" {
+		opts = append(opts, workerpool.WithDeadLetter(deadLetter))
+	}
+	if maxPayload > 0 {
+		opts = append(opts, workerpool.WithValidator(workerpool.MaxPayloadLength(int(maxPayload))))
+	}
 
-		// Drain resultsChan to ensure workers never block forever on send.
-		for range resultsChan {
+	// With -checkpoint, tasks completed by an earlier run are skipped and the
+	// writer keeps recording newly completed ones. A missing or unreadable
+	// checkpoint means starting from scratch.
+	var completed map[int]struct{}
+	if ckptPath != "" {
+		completed, err = workerpool.LoadCheckpoint(ckptPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			logger.Warn(fmt.Sprintf("no checkpoint at '%s'; starting fresh", ckptPath))
+		case err != nil:
+			logger.Warn(fmt.Sprintf("ignoring checkpoint: %v; starting fresh", err))
+		default:
+			logger.Info(fmt.Sprintf("Resuming from checkpoint '%s': %d task(s) already completed", ckptPath, len(completed)))
 		}
+		opts = append(opts, workerpool.WithCheckpoint(ckptPath, completed))
+	}
+
+	prod := &producer{completed: completed, pipeline: pipeline, filter: filter, taskTTL: taskTTL}
+	if dedup {
+		prod.seen = newDedupSet(dedupMax)
+	}
+
+	// With -dry-run the tasks go through the same producer steps as in a
+	// real run but are only counted: no worker starts, nothing is processed
+	// and no output file is created. A bad input file fails here, cheaply.
+	if dryRun {
+		if _, err := drainTasks(ctx, source, prod.wrap(func(workerpool.StringTask) {})); err != nil {
+			logger.Error(fmt.Sprintf("failed to read input %v", err))
+			os.Exit(1)
+		}
+		prod.logCounts(logger)
+		workers := fmt.Sprint(numWorkers)
+		if maxWorkers > 0 {
+			workers = fmt.Sprintf("%d-%d", numWorkers, maxWorkers)
+		}
+		out := outputPath
+		if out == "-" {
+			out = "<stdout>"
+		}
+		if newSink != nil {
+			out = fmt.Sprintf("the %s sink", sinkName)
+		}
+		logger.Info(fmt.Sprintf("Dry run: %d task(s) would be processed by %s worker(s), writing to %s", prod.submitted, workers, out))
 		return
 	}
-	defer func() {
-		if cerr := file.Close(); cerr != nil {
-			log.Printf("ERROR: failed to close output file: %v", cerr)
+
+	// Optionally expose Prometheus metrics, updated by workers as tasks finish.
+	// The server stops when ctx is cancelled.
+	var metricsStopped <-chan struct{}
+	if metricsAddr != "" {
+		reg := prometheus.NewRegistry()
+		opts = append(opts, workerpool.WithObserver(newPromMetrics(reg)))
+		metricsStopped = serveMetrics(ctx, logger, metricsAddr, reg)
+		logger.Info(fmt.Sprintf("Serving metrics on: %s/metrics", metricsAddr))
+	}
+
+	// Optionally trace the run: one span for the whole run and a child span
+	// per task. Without -otel-endpoint no tracer exists and nothing is traced.
+	var tracerProvider *sdktrace.TracerProvider
+	if otelEndpoint != "" {
+		tracerProvider, err = newTracerProvider(ctx, otelEndpoint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			flag.Usage()
+			os.Exit(2)
 		}
-	}()
+		opts = append(opts, workerpool.WithTracer(tracerProvider.Tracer("dataproc")))
+		logger.Info(fmt.Sprintf("Exporting traces to: %s", otelEndpoint))
+	}
 
-	buf := bufio.NewWriter(file)
-	defer func() {
-		if ferr := buf.Flush(); ferr != nil {
-			log.Printf("ERROR: failed to flush output buffer: %v", ferr)
+	// Optionally report progress to stderr once per second. The total is only
+	// known for the synthetic generator.
+	var progressStop, progressDone chan struct{}
+	if progress {
+		total := numTasks
+		if len(inputNames) > 0 || serveAddr != "" {
+			total = 0
 		}
-	}()
+		reporter := newProgressReporter(os.Stderr, total)
+		opts = append(opts, workerpool.WithObserver(reporter))
+
+		progressStop, progressDone = make(chan struct{}), make(chan struct{})
+		go reporter.run(time.Second, progressStop, progressDone)
+	}
+
+	// Collect totals for the end-of-run summary and the manifest.
+	var stats *runStats
+	if summaryMode != "none" || manifestOut != "" {
+		stats = newRunStats()
+		opts = append(opts, workerpool.WithObserver(stats))
+	}
 
-	for line := range resultsChan {
-		if _, werr := buf.WriteString(line); werr != nil {
-			log.Printf("ERROR: failed to write output line: %v", werr)
-			// Continue draining to avoid deadlock; output may be partial.
-			continue
+	// Cap the tasks accepted over HTTP but not finished yet. The limit
+	// learns about finished tasks as an observer.
+	var limit *pendingLimit
+	if serveAddr != "" && maxPending > 0 {
+		limit = &pendingLimit{max: int64(maxPending)}
+		opts = append(opts, workerpool.WithObserver(limit))
+	}
+
+	if seedSet {
+		logger.Info(fmt.Sprintf("RNG seed: %d", seed))
+	}
+
+	pool := workerpool.NewPool[string](numWorkers, workerpool.DecodePayloads(proc, payloadEnc), opts...)
+	handlePauseSignal(logger, pool)
+
+	var tlsConfig *tls.Config
+	if tlsCert != "" {
+		if tlsConfig, err = loadTLS(tlsCert, tlsKey); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+	var spill *spillQueue
+	if spillDir != "" {
+		if spill, err = newSpillQueue(spillDir, pool, logger); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
 		}
 	}
-}
 
-func main() {
-	// Parameters aligned with Java for direct comparison.
-	numWorkers := 4
-	numTasks := 20
+	// Make sure the output directory exists (target/ by default, so output
+	// lands in a predictable build artifact directory).
+	if newSink == nil && outputPath != "-" && !strings.HasPrefix(outputPath, "tcp://") {
+		_ = os.MkdirAll(filepath.Dir(outputPath), 0755)
+	}
 
-	// tasks acts as a concurrency-safe queue.
-	// Buffering to numTasks allows the producer to enqueue all tasks without blocking.
-	tasks := make(chan Task, numTasks)
+	logger.Info("Go system starting...")
+	if maxWorkers > 0 {
+		logger.Info(fmt.Sprintf("Workers: %d-%d (autoscaling)", numWorkers, maxWorkers))
+	} else {
+		logger.Info(fmt.Sprintf("Workers: %d", numWorkers))
+	}
+	if serveAddr != "" {
+		scheme := "HTTP"
+		if tlsConfig != nil {
+			scheme = "HTTPS"
+		}
+		logger.Info(fmt.Sprintf("Accepting tasks on: %s (%s POST /tasks, queue size %d)", serveAddr, scheme, numTasks))
+		if spill != nil {
+			logger.Info(fmt.Sprintf("Overflow spills to: %s", spill.path))
+		}
+		if limit != nil {
+			logger.Info(fmt.Sprintf("Pending tasks capped at: %d", maxPending))
+		}
+	} else if len(inputNames) > 0 {
+		logger.Info(fmt.Sprintf("Reading tasks from: %s", strings.Join(inputNames, ", ")))
+	} else if stream {
+		logger.Info(fmt.Sprintf("Streaming tasks: %d (queue size %d)", numTasks, queueSize))
+	} else {
+		logger.Info(fmt.Sprintf("Tasks loaded: %d", numTasks))
+	}
+	if newSink != nil {
+		logger.Info(fmt.Sprintf("Sending results to: the %s sink", sinkName))
+	} else if shards > 1 {
+		logger.Info(fmt.Sprintf("Writing output to: %s (%d shards by task ID)", outputPath, shards))
+	} else if shardBy == workerpool.ShardWorker {
+		logger.Info(fmt.Sprintf("Writing output to: %s (one file per worker)", outputPath))
+	} else if outputPath == "-" {
+		// Logs go to stderr, so stdout carries nothing but results.
+		logger.Info("Writing output to: <stdout>")
+	} else if strings.HasPrefix(outputPath, "tcp://") {
+		logger.Info(fmt.Sprintf("Sending output to: %s", outputPath))
+	} else if len(formats) > 1 {
+		names := make([]string, len(formats))
+		for i, f := range formats {
+			names[i] = string(f)
+		}
+		logger.Info(fmt.Sprintf("Writing output to: %s (one file per format: %s)", outputPath, strings.Join(names, ", ")))
+	} else if rotateSize > 0 || rotateEvery > 0 {
+		var every []string
+		if rotateSize > 0 {
+			every = append(every, rotateSize.String())
+		}
+		if rotateEvery > 0 {
+			every = append(every, rotateEvery.String())
+		}
+		logger.Info(fmt.Sprintf("Writing output to: %s (rotating every %s)", outputPath, strings.Join(every, " or ")))
+	} else if appendOut {
+		logger.Info(fmt.Sprintf("Appending output to: %s", outputPath))
+	} else {
+		logger.Info(fmt.Sprintf("Writing output to: %s", outputPath))
+	}
+	if taskTimeout > 0 {
+		if escalation > 1 {
+			logger.Info(fmt.Sprintf("Task timeout: %v (x%g per retry after a timeout)", taskTimeout, escalation))
+		} else {
+			logger.Info(fmt.Sprintf("Task timeout: %v", taskTimeout))
+		}
+	}
+	if rateLimit > 0 {
+		logger.Info(fmt.Sprintf("Rate limit: %g tasks/s", rateLimit))
+	}
+	if maxConcurrent > 0 && maxConcurrent < max(numWorkers, maxWorkers) {
+		logger.Info(fmt.Sprintf("Max concurrency: %d tasks at once", maxConcurrent))
+	}
+	if dispatch == workerpool.DispatchRoundRobin {
+		logger.Info("Dispatch: round-robin")
+	}
+	if maxInflight > 0 {
+		logger.Info(fmt.Sprintf("Max in-flight payload: %v", &maxInflight))
+	}
+	if taskTTL > 0 {
+		logger.Info(fmt.Sprintf("Task TTL: %v", taskTTL))
+	}
+	if noDelay && !bench {
+		logger.Info("Simulated delay: off")
+	}
+	if bench {
+		delay := "off"
+		if benchDelay {
+			delay = "on"
+		}
+		logger.Info(fmt.Sprintf("Benchmark mode: simulated delay %s", delay))
+	}
+	if breakerMax > 0 {
+		logger.Info(fmt.Sprintf("Circuit breaker: open after %d consecutive failures for %v", breakerMax, breakerWait))
+	}
 
-	// resultsChan decouples compute from disk I/O.
-	// Buffering prevents workers from blocking on every single write.
-	resultsChan := make(chan string, numTasks)
+	// The run span is the parent of every task span: the producer and the
+	// workers all use its context.
+	var runSpan trace.Span
+	if tracerProvider != nil {
+		ctx, runSpan = tracerProvider.Tracer("dataproc").Start(ctx, "run", trace.WithAttributes(
+			attribute.Int("workers", numWorkers),
+			attribute.Int("max_workers", maxWorkers),
+		))
+	}
+
+	// Profile from the first task on; the profile is complete once Run has
+	// returned.
+	var stopProfile func() error
+	if cpuProfile != "" {
+		if stopProfile, err = startCPUProfile(cpuProfile); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// The heartbeat runs until Run has returned.
+	var heartbeatStop, heartbeatDone chan struct{}
+	if heartbeatEvery > 0 {
+		heartbeatStop, heartbeatDone = make(chan struct{}), make(chan struct{})
+		go heartbeat(logger, pool, heartbeatEvery, heartbeatStop, heartbeatDone)
+	}
 
-	// done is closed by writer when the output file is fully flushed and closed.
-	done := make(chan struct{})
+	// So does the watchdog. A hung run cannot be shut down cleanly, so
+	// -watchdog-abort leaves the goroutine stacks for the post-mortem and
+	// exits at once.
+	var watchdogStop, watchdogDone chan struct{}
+	if watchdogAfter > 0 {
+		var trip func()
+		if watchdogAbort {
+			trip = func() {
+				pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+				logger.Error("Watchdog: aborting the run", "event", "WATCHDOG")
+				os.Exit(1)
+			}
+		}
+		watchdogStop, watchdogDone = make(chan struct{}), make(chan struct{})
+		go watchdog(logger, pool, watchdogAfter, trip, watchdogStop, watchdogDone)
+	}
 
-	// Store output in a predictable build artifact directory.
-	_ = os.MkdirAll("target", 0755)
-	outputPath := "target/go-output.txt"
+	// Produce tasks from the selected TaskSource (or over HTTP with -serve)
+	// concurrently with the pool so the producer may block on a full queue
+	// without deadlocking. Cancellation is checked between submissions so a
+	// shutdown request stops new work from being queued. An input that
+	// cannot be read aborts the run: the error is handed over on inputErr
+	// before ctx is cancelled, so it is there once Run returns.
+	inputErr := make(chan error, 1)
+	go func() {
+		// Close the queue to signal that no more tasks will be added.
+		// Workers will finish naturally after draining it.
+		defer pool.Close()
 
-	log.Println("Go system starting...")
-	log.Printf("Workers: %d", numWorkers)
-	log.Printf("Tasks loaded: %d", numTasks)
-	log.Printf("Writing output to: %s", outputPath)
+		// Feed nothing until every worker has started, so the first burst
+		// of tasks (or requests in -serve mode) meets the whole pool.
+		if err := pool.WaitReady(ctx); err != nil {
+			return
+		}
+		logger.Debug(fmt.Sprintf("All %d workers ready", numWorkers))
 
-	// Start the dedicated writer goroutine (owns the shared output resource).
-	go writer(outputPath, resultsChan, done)
+		submit := prod.wrap(pool.Submit)
+		aborted := false
 
-	// Start worker goroutines.
-	var wg sync.WaitGroup
-	wg.Add(numWorkers)
-	for w := 1; w <= numWorkers; w++ {
-		go worker(w, tasks, resultsChan, &wg)
+		if serveAddr != "" {
+			// Runs until the context is cancelled; the queue is closed only
+			// once no request handler can submit anymore.
+			<-serveTasks(ctx, logger, serveAddr, pool, taskTTL, idOffset, spill, tlsConfig, limit)
+		} else {
+			read, err := drainTasks(ctx, source, submit)
+			if err != nil && ctx.Err() == nil {
+				err = fmt.Errorf("failed to read input %w", err)
+				logger.Error(err.Error()+"; stopping the run", "event", "INPUT_FAILED")
+				inputErr <- err
+				cancel()
+				aborted = true
+			}
+			// The synthetic count was already logged at startup.
+			if len(inputNames) > 0 {
+				logger.Info(fmt.Sprintf("Tasks loaded: %d", prod.submitted))
+			}
+			if read == 0 && err == nil && len(inputNames) > 0 {
+				// Not an error: the run completes normally with empty output.
+				logger.Warn(fmt.Sprintf("Input is empty: %s holds no tasks", strings.Join(inputNames, ", ")), "event", "EMPTY_INPUT")
+			}
+			if replay != nil && replay.skipped > 0 {
+				logger.Warn(fmt.Sprintf("Replay skipped %d malformed line(s) of '%s'", replay.skipped, replayPath), "event", "REPLAY_SKIPPED")
+			}
+		}
+		prod.logCounts(logger)
+		if ctx.Err() != nil && !aborted {
+			logger.Info("Shutdown requested: no further tasks will be queued")
+		}
+	}()
+
+	// Run blocks until every task is handled and the output is flushed. It
+	// opens the output before starting any worker, so an unwritable
+	// destination makes it return at once, before any work is done.
+	started := time.Now()
+	runErr := pool.Run(ctx)
+	ended := time.Now()
+	if runErr != nil {
+		logger.Error(runErr.Error())
+	}
+	var readErr error
+	select {
+	case readErr = <-inputErr:
+	default:
+	}
+	if stopProfile != nil {
+		if err := stopProfile(); err != nil {
+			logger.Error(err.Error())
+		} else {
+			logger.Info(fmt.Sprintf("CPU profile written to: %s", cpuProfile))
+		}
+	}
+	// Tasks still queued or in progress when the deadline expired were
+	// abandoned; the writer has written everything that finished.
+	cutOff := runDeadline > 0 && readErr == nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+	var abandoned int64
+	if cutOff {
+		st := pool.Stats()
+		abandoned = st.Submitted - st.Completed - st.Failed
+		logger.Warn(fmt.Sprintf("Run cut off by -deadline=%v: %d task(s) abandoned", runDeadline, abandoned),
+			"event", "DEADLINE", "abandoned", abandoned)
+		if stats != nil {
+			stats.cutOff(runDeadline, abandoned)
+		}
+	}
+	if bench {
+		st := pool.Stats()
+		if err := printBench(os.Stdout, numWorkers, st.Completed+st.Failed, ended.Sub(started)); err != nil {
+			logger.Error(fmt.Sprintf("failed to print benchmark result: %v", err))
+		}
 	}
 
-	// Produce tasks.
-	for i := 1; i <= numTasks; i++ {
-		tasks <- Task{ID: i, Payload: fmt.Sprintf("data-%d", i)}
+	if heartbeatStop != nil {
+		close(heartbeatStop)
+		<-heartbeatDone
+	}
+	if watchdogStop != nil {
+		close(watchdogStop)
+		<-watchdogDone
 	}
 
-	// Close tasks channel to signal that no more tasks will be added.
-	// Workers will finish naturally after draining the channel.
-	close(tasks)
+	// Print the final progress line once every task is done.
+	if progressStop != nil {
+		close(progressStop)
+		<-progressDone
+	}
 
-	// Wait until all workers have completed processing.
-	wg.Wait()
+	// Stop the metrics server (if any) now that the run is complete.
+	cancel()
+	if metricsStopped != nil {
+		<-metricsStopped
+	}
 
-	// Close results channel to signal writer to finish.
-	close(resultsChan)
+	// End the run span and export what is still batched before exiting.
+	if runSpan != nil {
+		if runErr != nil {
+			runSpan.RecordError(runErr)
+			runSpan.SetStatus(codes.Error, runErr.Error())
+		}
+		runSpan.End()
+		shutdownTracing(logger, tracerProvider)
+	}
 
-	// Wait for writer to flush and close file.
-	<-done
+	logger.Info("Go system ended.")
 
-	log.Println("Go system ended.")
+	// The manifest is written only now that every output file is flushed and
+	// closed, so the checksums match what consumers will read.
+	manifestErr := false
+	if manifestOut != "" {
+		sum := stats.snapshot(started, ended)
+		m := &manifest{
+			Source:     "generator",
+			Workers:    numWorkers,
+			MaxWorkers: maxWorkers,
+			Start:      started,
+			End:        ended,
+			Tasks:      sum.Tasks,
+			Succeeded:  sum.Succeeded,
+			Failed:     sum.Failed,
+		}
+		switch {
+		case serveAddr != "":
+			m.Source, m.Inputs = "http", []string{serveAddr}
+		case replayPath != "":
+			m.Source, m.Inputs = "replay", inputNames
+		case len(inputPaths) > 0 || inputJSON != "":
+			m.Source, m.Inputs = "files", inputNames
+		}
+		switch {
+		case runErr != nil:
+			m.Error = runErr.Error()
+		case readErr != nil:
+			m.Error = readErr.Error()
+		case cutOff:
+			m.Error = fmt.Sprintf("run cut off by -deadline=%v: %d task(s) abandoned", runDeadline, abandoned)
+		}
+		if err := m.write(manifestOut, pool.OutputFiles(), appendOut); err != nil {
+			logger.Error(err.Error())
+			manifestErr = true
+		} else {
+			logger.Info(fmt.Sprintf("Manifest written to: %s", manifestOut))
+		}
+	}
+
+	if summaryMode != "none" {
+		if err := stats.print(os.Stderr, summaryMode == "json", started, ended, pool.WorkerStats(), pool.OutputFiles()); err != nil {
+			logger.Error(fmt.Sprintf("failed to print summary: %v", err))
+		}
+	}
+
+	// Exit non-zero for CI when the output is incomplete or missing, when
+	// an input could not be read, when any task failed permanently, or when
+	// -deadline cut the run off. Run has already flushed and closed every
+	// output file, so nothing is lost by exiting here.
+	if runErr != nil || readErr != nil || manifestErr || cutOff || pool.Failed() > 0 {
+		os.Exit(1)
+	}
 }
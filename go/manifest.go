@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"dataproc/workerpool"
+)
+
+// manifest describes a finished run for audit trails; the JSON tags are the
+// keys of the -manifest file.
+type manifest struct {
+	// Source is "generator", "files", "replay" or "http"; Inputs lists the
+	// files read ("<stdin>" for -input=-), the -replay file or the -serve
+	// address.
+	Source     string   `json:"source"`
+	Inputs     []string `json:"inputs,omitempty"`
+	Workers    int      `json:"workers"`
+	MaxWorkers int      `json:"max_workers,omitempty"`
+
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Tasks     int64     `json:"tasks"`
+	Succeeded int64     `json:"succeeded"`
+	Failed    int64     `json:"failed"`
+
+	// Outputs is empty when the results went to standard output.
+	Outputs []manifestOutput `json:"outputs"`
+
+	// Error is set when the output could not be written completely, or the
+	// run was cut off by -deadline.
+	Error string `json:"error,omitempty"`
+}
+
+// manifestOutput is one output file and the checksum of its final contents.
+type manifestOutput struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// hashFile returns the size and hex SHA-256 of the file at path. It reads
+// the file back from disk, so it must only be called once the writer has
+// flushed and closed it. It is only needed in append mode, where the
+// writer's digest covers just the bytes appended by this run.
+func hashFile(path string) (manifestOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return manifestOutput{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return manifestOutput{}, fmt.Errorf("failed to hash '%s': %w", path, err)
+	}
+	return manifestOutput{Path: path, Bytes: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// write records outputs, with the digests the writer computed as it wrote
+// them, and saves the manifest as indented JSON at path. With rehash (append
+// mode) every file is read back instead, so the checksum covers its whole
+// content. Like the checkpoint, it writes a temporary file and renames it,
+// so a reader never sees a half-written manifest.
+func (m *manifest) write(path string, outputs []workerpool.OutputFile, rehash bool) error {
+	m.Outputs = []manifestOutput{}
+	for _, out := range outputs {
+		o := manifestOutput{Path: out.Path, Bytes: out.Bytes, SHA256: out.SHA256}
+		if rehash {
+			var err error
+			if o, err = hashFile(out.Path); err != nil {
+				return err
+			}
+		}
+		m.Outputs = append(m.Outputs, o)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace manifest: %w", err)
+	}
+	return nil
+}
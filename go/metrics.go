@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"dataproc/workerpool"
+)
+
+// promMetrics is a workerpool.Observer that records task outcomes as
+// Prometheus metrics. The collectors are safe for concurrent use, so workers
+// update them directly without extra locking.
+type promMetrics struct {
+	processed prometheus.Counter
+	failed    prometheus.Counter
+	duration  prometheus.Histogram
+}
+
+// newPromMetrics creates the collectors and registers them with reg.
+func newPromMetrics(reg prometheus.Registerer) *promMetrics {
+	m := &promMetrics{
+		processed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dataproc_tasks_processed_total",
+			Help: "Number of tasks processed successfully.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dataproc_tasks_failed_total",
+			Help: "Number of tasks that failed after all retries.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "dataproc_task_duration_seconds",
+			Help:    "Time spent processing a task, including retries.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}),
+	}
+	reg.MustRegister(m.processed, m.failed, m.duration)
+	return m
+}
+
+// TaskFinished implements workerpool.Observer.
+func (m *promMetrics) TaskFinished(r workerpool.Result, elapsed time.Duration) {
+	if r.Err != nil {
+		m.failed.Inc()
+	} else {
+		m.processed.Inc()
+	}
+	m.duration.Observe(elapsed.Seconds())
+}
+
+// serveMetrics exposes the metrics in reg on addr at /metrics until ctx is
+// cancelled, then shuts the server down so the process can exit. It returns
+// a channel that is closed once the server has stopped.
+func serveMetrics(ctx context.Context, logger *slog.Logger, addr string, reg *prometheus.Registry) <-chan struct{} {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(fmt.Sprintf("metrics server on %s failed: %v", addr, err))
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error(fmt.Sprintf("metrics server shutdown: %v", err))
+		}
+	}()
+
+	return stopped
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"dataproc/workerpool"
+)
+
+// producer holds the steps every task passes through between its TaskSource
+// and the pool: the checkpoint skip list, -transforms, -filter, -dedup and
+// the -task-ttl deadline. It is only used by the producer goroutine, so the
+// counters need no locking.
+type producer struct {
+	completed map[int]struct{}
+	pipeline  []transform
+	filter    *regexp.Regexp
+	seen      *dedupSet
+	taskTTL   time.Duration
+
+	submitted, filtered, resumed int
+}
+
+// wrap returns a submit function that runs each task through the steps, in
+// order, and passes the survivors to submit:
+//   - tasks recorded in the checkpoint were completed by an earlier run and
+//     are skipped;
+//   - payloads are transformed before anything else looks at them, so the
+//     filter and the dedup set see the rewritten payload;
+//   - tasks not matching the filter are dropped before deduplication, so they
+//     never occupy a slot in the dedup set;
+//   - duplicates of an already submitted payload are dropped;
+//   - the rest are stamped with a deadline if a TTL is set, so workers drop
+//     them if they are still queued when it passes.
+func (p *producer) wrap(submit func(workerpool.StringTask)) func(workerpool.StringTask) {
+	return func(t workerpool.StringTask) {
+		if _, ok := p.completed[t.ID]; ok {
+			p.resumed++
+			return
+		}
+		t.Payload = applyTransforms(p.pipeline, t.Payload)
+		if p.filter != nil && !p.filter.MatchString(t.Payload) {
+			p.filtered++
+			return
+		}
+		if p.seen != nil && p.seen.duplicate(t.Payload) {
+			return
+		}
+		if p.taskTTL > 0 {
+			t.Deadline = time.Now().Add(p.taskTTL)
+		}
+		p.submitted++
+		submit(t)
+	}
+}
+
+// logCounts logs how many tasks each enabled step dropped.
+func (p *producer) logCounts(logger *slog.Logger) {
+	if p.seen != nil {
+		logger.Info(fmt.Sprintf("Duplicates skipped: %d", p.seen.skipped))
+	}
+	if p.filter != nil {
+		logger.Info(fmt.Sprintf("Tasks filtered out: %d", p.filtered))
+	}
+	if p.resumed > 0 {
+		logger.Info(fmt.Sprintf("Skipped %d task(s) completed in checkpoint", p.resumed))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"dataproc/workerpool"
+)
+
+// progressReporter is a workerpool.Observer that counts finished tasks and
+// periodically prints how far along the run is. Workers only touch the
+// atomic counter, so reporting adds no locking to the hot path.
+type progressReporter struct {
+	out   io.Writer
+	total int // 0 when the task count is not known up front (e.g. -input)
+	start time.Time
+	done  atomic.Int64
+}
+
+func newProgressReporter(out io.Writer, total int) *progressReporter {
+	return &progressReporter{out: out, total: total, start: time.Now()}
+}
+
+// TaskFinished implements workerpool.Observer.
+func (p *progressReporter) TaskFinished(workerpool.Result, time.Duration) {
+	p.done.Add(1)
+}
+
+// run prints a progress line every interval until stop is closed, then prints
+// a final summary line and closes finished.
+func (p *progressReporter) run(interval time.Duration, stop <-chan struct{}, finished chan<- struct{}) {
+	defer close(finished)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.print("processed")
+		case <-stop:
+			p.print("finished: processed")
+			return
+		}
+	}
+}
+
+// print writes one progress line, e.g. "processed 342/1000 (34%) ~12.3/s",
+// with "~n/a" as the rate if no time has measurably passed yet.
+func (p *progressReporter) print(label string) {
+	done := p.done.Load()
+	rate := formatRate(done, time.Since(p.start), "/s")
+
+	if p.total > 0 {
+		pct := float64(done) * 100 / float64(p.total)
+		fmt.Fprintf(p.out, "%s %d/%d (%.0f%%) ~%s\n", label, done, p.total, pct, rate)
+		return
+	}
+	fmt.Fprintf(p.out, "%s %d ~%s\n", label, done, rate)
+}
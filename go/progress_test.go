@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dataproc/workerpool"
+)
+
+// quietLogger keeps the pool's log out of the test output.
+func quietLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// TestProgressConcurrentSubmitAndClose submits from several goroutines while
+// another closes the pool and the reporter prints, so that `go test -race`
+// covers every access to the progress counter. Every accepted task must be
+// counted exactly once.
+func TestProgressConcurrentSubmitAndClose(t *testing.T) {
+	proc := workerpool.NewSimulatedProcessor()
+	proc.DisableDelay()
+	var out bytes.Buffer
+	reporter := newProgressReporter(&out, 0)
+	sink := &workerpool.InMemorySink{}
+	pool := workerpool.NewPool[string](4, proc,
+		workerpool.WithSink(sink),
+		workerpool.WithObserver(reporter),
+		workerpool.WithLogger(quietLogger()),
+	)
+
+	stop, finished := make(chan struct{}), make(chan struct{})
+	go reporter.run(time.Millisecond, stop, finished)
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- pool.Run(context.Background()) }()
+
+	const producers, perProducer = 8, 200
+	var accepted atomic.Int64
+	var closeOnce sync.Once
+	var wg sync.WaitGroup
+	for g := range producers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perProducer {
+				id := g*perProducer + i + 1
+				err := pool.SubmitCtx(context.Background(), workerpool.StringTask{ID: id, Payload: fmt.Sprintf("data-%d", id)})
+				if err != nil {
+					if !errors.Is(err, workerpool.ErrPoolClosed) {
+						t.Errorf("SubmitCtx(Task-%d) = %v, want nil or ErrPoolClosed", id, err)
+					}
+					return
+				}
+				if accepted.Add(1) == producers*perProducer/4 {
+					// Close while the other producers are still submitting.
+					go closeOnce.Do(pool.Close)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	closeOnce.Do(pool.Close)
+
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	close(stop)
+	<-finished
+
+	want := accepted.Load()
+	if got := reporter.done.Load(); got != want {
+		t.Errorf("progress counted %d tasks, want %d", got, want)
+	}
+	if got := len(sink.Results()); int64(got) != want {
+		t.Errorf("sink got %d results, want %d", got, want)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if last, prefix := lines[len(lines)-1], fmt.Sprintf("finished: processed %d ~", want); !strings.HasPrefix(last, prefix) {
+		t.Errorf("last progress line = %q, want prefix %q", last, prefix)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dataproc/workerpool"
+)
+
+// retryAfter is the Retry-After value, in seconds, sent with a 429 when
+// -max-pending is reached.
+const retryAfter = "1"
+
+// pendingLimit is the -max-pending cap: the number of tasks accepted over
+// HTTP that have not finished yet, wherever they wait (in the queue, in the
+// spill file or in a worker). It is a workerpool.Observer, so a task stops
+// counting once a worker is done with it, in any outcome.
+type pendingLimit struct {
+	max     int64
+	pending atomic.Int64
+}
+
+// acquire counts one more pending task, or returns false, counting nothing,
+// if the cap is already reached.
+func (l *pendingLimit) acquire() bool {
+	if l.pending.Add(1) > l.max {
+		l.pending.Add(-1)
+		return false
+	}
+	return true
+}
+
+// release undoes an acquire for a task that was not accepted after all.
+func (l *pendingLimit) release() {
+	l.pending.Add(-1)
+}
+
+// TaskFinished implements workerpool.Observer.
+func (l *pendingLimit) TaskFinished(workerpool.Result, time.Duration) {
+	l.pending.Add(-1)
+}
+
+// taskServer accepts tasks over HTTP in -serve mode. IDs are assigned in
+// arrival order, starting at 1 (after -id-offset), and only consumed by
+// accepted tasks and by those the Validator refused. With a
+// ttl, each task gets a Deadline of ttl after it was accepted. With spill
+// (-spill-dir), tasks that find the queue full go to disk instead of being
+// rejected. With limit (-max-pending), tasks beyond the cap are rejected
+// with 429 before they reach either.
+type taskServer struct {
+	pool   *workerpool.Pool[string]
+	logger *slog.Logger
+	ttl    time.Duration
+	spill  *spillQueue
+	limit  *pendingLimit
+
+	mu     sync.Mutex
+	nextID int
+}
+
+// submitRequest is the body of POST /tasks.
+type submitRequest struct {
+	Payload string `json:"payload"`
+}
+
+// submitResponse is returned for an accepted task.
+type submitResponse struct {
+	ID int `json:"id"`
+}
+
+// handleSubmit queues the posted payload as a new Task. It never blocks on
+// the queue: when it is full the client gets 503 and may retry later, or,
+// with a spill queue, the task is spilled to disk. Once -max-pending tasks
+// are pending the client gets 429 with a Retry-After header. A task the
+// pool's Validator refuses gets 400; it is reported as failed under its ID,
+// so the ID is used up, but it never counts as pending.
+func (s *taskServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req submitRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLineSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	task := workerpool.StringTask{Payload: req.Payload}
+	if s.ttl > 0 {
+		task.Deadline = time.Now().Add(s.ttl)
+	}
+
+	if s.limit != nil && !s.limit.acquire() {
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, fmt.Sprintf("too many pending tasks (limit %d), retry later", s.limit.max), http.StatusTooManyRequests)
+		return
+	}
+
+	s.mu.Lock()
+	id := s.nextID + 1
+	task.ID = id
+	var err error
+	if s.spill != nil {
+		err = s.spill.submit(task)
+	} else {
+		err = s.pool.TrySubmit(task)
+	}
+	invalid := errors.Is(err, workerpool.ErrInvalidTask)
+	if err == nil || invalid {
+		s.nextID = id
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		if s.limit != nil {
+			s.limit.release()
+		}
+		if invalid {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !errors.Is(err, workerpool.ErrQueueFull) {
+			// The spill file could not be written.
+			s.logger.Error(err.Error(), "task", id)
+		}
+		http.Error(w, "task queue is full, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	s.logger.Debug(fmt.Sprintf("Accepted Task-%d over HTTP", id), "task", id, "event", "SUBMITTED")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(submitResponse{ID: id})
+}
+
+// handleHealth serves the GET /healthz and /readyz probes from Pool.Health:
+// 200 "ok" while the workers run and the pool is open, 503 with the reason
+// once it is shutting down or its output failed.
+func (s *taskServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := s.pool.Health(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// loadTLS loads the -tls-cert and -tls-key pair up front, so a missing or
+// mismatched file stops the program before it starts serving.
+func loadTLS(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate '%s' and key '%s': %w", certFile, keyFile, err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// serveTasks runs the POST /tasks endpoint, and the health probes, on addr
+// until ctx is cancelled, then shuts the server down, letting in-flight
+// requests finish. The returned channel is closed once the server has
+// stopped and no handler can submit anymore, so the caller may then close
+// the pool. A non-nil spill queue is drained into the pool meanwhile, and
+// closed before that. With a non-nil tlsConfig (see loadTLS) the server
+// speaks HTTPS only. A non-nil limit must also be registered as an observer
+// of pool.
+func serveTasks(ctx context.Context, logger *slog.Logger, addr string, pool *workerpool.Pool[string], ttl time.Duration, idOffset int, spill *spillQueue, tlsConfig *tls.Config, limit *pendingLimit) <-chan struct{} {
+	s := &taskServer{pool: pool, logger: logger, ttl: ttl, spill: spill, limit: limit, nextID: idOffset}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tasks", s.handleSubmit)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleHealth)
+	srv := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
+	// Connection-level errors, such as failed TLS handshakes, go to the
+	// run's log instead of the standard logger.
+	srv.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelWarn)
+
+	serveDone := make(chan struct{})
+	go func() {
+		defer close(serveDone)
+		var err error
+		if tlsConfig != nil {
+			// The certificate is already in TLSConfig.
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(fmt.Sprintf("task server on %s failed: %v", addr, err))
+		}
+	}()
+
+	drainCtx, stopDrain := context.WithCancel(ctx)
+	drainDone := make(chan struct{})
+	go func() {
+		defer close(drainDone)
+		if spill != nil {
+			spill.drain(drainCtx)
+		}
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer func() {
+			stopDrain()
+			<-drainDone
+			if spill != nil {
+				spill.close()
+			}
+		}()
+		select {
+		case <-ctx.Done():
+		case <-serveDone:
+			// The listener failed; nothing can be submitted anymore.
+			return
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error(fmt.Sprintf("task server shutdown: %v", err))
+		}
+		<-serveDone
+	}()
+
+	return stopped
+}
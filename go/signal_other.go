@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"log/slog"
+
+	"dataproc/workerpool"
+)
+
+// handlePauseSignal is a no-op where SIGUSR1 does not exist; the pool can
+// still be paused through Pool.Pause when used as a library.
+func handlePauseSignal[T any](logger *slog.Logger, pool *workerpool.Pool[T]) {}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"dataproc/workerpool"
+)
+
+// handlePauseSignal toggles pool between paused and running on every
+// SIGUSR1, e.g. `kill -USR1 <pid>`, to relieve a downstream system without
+// stopping the run. SIGINT/SIGTERM still shut a paused pool down cleanly.
+func handlePauseSignal[T any](logger *slog.Logger, pool *workerpool.Pool[T]) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+
+	go func() {
+		for range sigs {
+			if pool.Pause() {
+				logger.Info("Received SIGUSR1: pausing; workers finish their current task (SIGUSR1 again to resume)", "event", "PAUSE")
+				continue
+			}
+			pool.Resume()
+			logger.Info("Received SIGUSR1: resuming", "event", "RESUME")
+		}
+	}()
+}
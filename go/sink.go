@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"dataproc/workerpool"
+)
+
+// sinks maps the names accepted by -sink to the result sinks that replace
+// the -out destination; "out", the default, keeps it. A new destination only
+// needs a workerpool.Sink and an entry here.
+var sinks = map[string]func(*slog.Logger) workerpool.Sink{
+	"log":     func(logger *slog.Logger) workerpool.Sink { return logSink{logger} },
+	"discard": func(*slog.Logger) workerpool.Sink { return discardSink{} },
+}
+
+// parseSink checks a -sink name at startup and returns its constructor, or
+// nil for "out".
+func parseSink(name string) (func(*slog.Logger) workerpool.Sink, error) {
+	if name == "out" {
+		return nil, nil
+	}
+	newSink, ok := sinks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q (want out, log or discard)", name)
+	}
+	return newSink, nil
+}
+
+// outputSink returns the sink for the -out destination: standard output for
+// "-", a connection to the collector for tcp://HOST:PORT, and the file at
+// path otherwise.
+func outputSink(path string) workerpool.Sink {
+	if path == "-" {
+		return workerpool.StdoutSink()
+	}
+	if addr, ok := strings.CutPrefix(path, "tcp://"); ok {
+		return workerpool.TCPSink(addr)
+	}
+	return workerpool.FileSink(path)
+}
+
+// logSink writes each result as a log record (event RESULT) instead of an
+// output line, for deployments that collect the log anyway. Successful
+// results are logged at info level and failed ones at warn level.
+type logSink struct {
+	logger *slog.Logger
+}
+
+func (s logSink) Write(r workerpool.Result) error {
+	if r.Err != nil {
+		s.logger.Warn(fmt.Sprintf("Task-%d failed: %v", r.TaskID, r.Err),
+			"task", r.TaskID, "worker", r.WorkerID, "event", "RESULT", "attempts", r.Attempts)
+		return nil
+	}
+	s.logger.Info(fmt.Sprintf("Task-%d: %s", r.TaskID, r.Payload),
+		"task", r.TaskID, "worker", r.WorkerID, "event", "RESULT", "attempts", r.Attempts)
+	return nil
+}
+
+func (logSink) Close() error { return nil }
+
+// discardSink drops every result. Observers still see them, so the summary,
+// progress and metrics are unaffected; only the output is skipped, e.g. to
+// measure the pool without any I/O.
+type discardSink struct{}
+
+func (discardSink) Write(workerpool.Result) error { return nil }
+
+func (discardSink) Close() error { return nil }
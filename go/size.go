@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteSize is a flag value holding a number of bytes. It accepts a plain
+// number or one with a B, KB, MB or GB suffix (case-insensitive, powers of
+// 1024), e.g. "512", "64KB" or "10MB".
+type byteSize int64
+
+// sizeUnits is ordered so longer suffixes are tried before "B".
+var sizeUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	for _, u := range sizeUnits {
+		if *b >= byteSize(u.n) && int64(*b)%u.n == 0 {
+			return fmt.Sprintf("%d%s", int64(*b)/u.n, u.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return errors.New("want a non-negative size such as 512, 64KB or 10MB")
+	}
+	if n > math.MaxInt64/unit {
+		return fmt.Errorf("%s is too large", value)
+	}
+	*b = byteSize(n * unit)
+	return nil
+}
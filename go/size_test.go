@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestByteSizeSet(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    byteSize
+		wantErr bool
+	}{
+		{in: "512", want: 512},
+		{in: "64kb", want: 64 << 10},
+		{in: " 10 MB ", want: 10 << 20},
+		{in: "8GB", want: 8 << 30},
+		{in: "8589934591GB", want: 8589934591 << 30},
+		{in: "8589934592GB", wantErr: true},
+		{in: "99999999999GB", wantErr: true},
+		{in: "9223372036854775807B", want: 1<<63 - 1},
+		{in: "-1", wantErr: true},
+		{in: "ten", wantErr: true},
+	} {
+		var b byteSize
+		err := b.Set(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Set(%q) error = %v, want error %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && b != tc.want {
+			t.Errorf("Set(%q) = %d, want %d", tc.in, b, tc.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"dataproc/workerpool"
+)
+
+// spillQueue is the disk-backed overflow of -spill-dir. In -serve mode a
+// task that does not fit in the full in-memory queue is appended to a spill
+// file as one JSON line (the -input-json element format) instead of being
+// rejected, and drain feeds the spilled tasks back into the pool, oldest
+// first, as room frees up. Once anything is spilled, new tasks are spilled
+// too until the file is drained, so tasks still reach the pool in arrival
+// order.
+//
+// The file is written and read through separate handles. A record is
+// written with a single Write while mu is held, and only counted in pending
+// afterwards, so the reader never sees half a line. Once every record has
+// been drained, the file is truncated so it does not grow for the whole
+// life of the service.
+type spillQueue struct {
+	pool   *workerpool.Pool[string]
+	logger *slog.Logger
+	path   string
+
+	mu      sync.Mutex
+	w       *os.File
+	r       *os.File
+	reader  *bufio.Reader
+	pending int
+	spilled int
+
+	// wake has room for one signal: drain only needs to know that there is
+	// something to read, not how much.
+	wake chan struct{}
+}
+
+// newSpillQueue creates a spill file in dir, creating dir if needed.
+func newSpillQueue(dir string, pool *workerpool.Pool[string], logger *slog.Logger) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory '%s': %w", dir, err)
+	}
+	w, err := os.CreateTemp(dir, "dataproc-spill-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file in '%s': %w", dir, err)
+	}
+	r, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	return &spillQueue{
+		pool:   pool,
+		logger: logger,
+		path:   w.Name(),
+		w:      w,
+		r:      r,
+		reader: bufio.NewReader(r),
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// submit queues task in the pool if it has room and nothing is spilled,
+// and spills it otherwise. A task the pool's Validator refuses is never
+// spilled; the error, wrapping workerpool.ErrInvalidTask, is returned. The
+// caller serializes calls (see taskServer).
+func (q *spillQueue) submit(task workerpool.StringTask) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == 0 {
+		if err := q.pool.TrySubmit(task); !errors.Is(err, workerpool.ErrQueueFull) {
+			return err
+		}
+	} else if err := q.pool.Validate(task); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(jsonTask{
+		ID:        &task.ID,
+		Payload:   &task.Payload,
+		Priority:  task.Priority,
+		Weight:    task.Weight,
+		DependsOn: task.DependsOn,
+		Deadline:  task.Deadline,
+		Meta:      task.Meta,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := q.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to spill Task-%d: %w", task.ID, err)
+	}
+	if q.pending == 0 {
+		q.logger.Info(fmt.Sprintf("Task queue full: spilling tasks to %s", q.path), "event", "SPILLING")
+	}
+	q.pending++
+	q.spilled++
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next reads the oldest spilled task, or returns false if there is none.
+func (q *spillQueue) next() (workerpool.StringTask, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == 0 {
+		return workerpool.StringTask{}, false, nil
+	}
+	line, err := q.reader.ReadBytes('\n')
+	if err != nil {
+		return workerpool.StringTask{}, false, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	var t jsonTask
+	if err := json.Unmarshal(line, &t); err != nil {
+		return workerpool.StringTask{}, false, fmt.Errorf("corrupt spill record: %w", err)
+	}
+	return t.task(0), true, nil
+}
+
+// drained records that a task returned by next is in the pool, and empties
+// the file once nothing is left in it.
+func (q *spillQueue) drained() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending--; q.pending > 0 {
+		return
+	}
+	q.logger.Info("Spilled tasks drained back into the task queue", "event", "SPILL_DRAINED")
+	// The writer appends, so after truncating both handles start over.
+	if err := q.w.Truncate(0); err != nil {
+		q.logger.Warn(fmt.Sprintf("failed to truncate spill file: %v", err))
+		return
+	}
+	if _, err := q.r.Seek(0, io.SeekStart); err != nil {
+		q.logger.Warn(fmt.Sprintf("failed to rewind spill file: %v", err))
+		return
+	}
+	q.reader.Reset(q.r)
+}
+
+// drain moves spilled tasks into the pool until ctx is cancelled, blocking
+// while the queue is full. A task the pool's Validator refuses anyway is
+// reported by the pool and skipped. A read error stops it, leaving the rest
+// of the spilled tasks undelivered.
+func (q *spillQueue) drain(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		}
+		for {
+			task, ok, err := q.next()
+			if err != nil {
+				q.logger.Error(err.Error())
+				return
+			}
+			if !ok {
+				break
+			}
+			if err := q.pool.SubmitCtx(ctx, task); err != nil && !errors.Is(err, workerpool.ErrInvalidTask) {
+				return
+			}
+			q.drained()
+		}
+	}
+}
+
+// close removes the spill file. Tasks still in it were accepted but never
+// queued; like the tasks in the in-memory queue on shutdown, they are not
+// processed, and only counted here.
+func (q *spillQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending > 0 {
+		q.logger.Warn(fmt.Sprintf("%d spilled task(s) were never queued", q.pending), "event", "SPILL_DROPPED")
+	}
+	if q.spilled > 0 {
+		q.logger.Info(fmt.Sprintf("Tasks spilled to disk: %d", q.spilled), "spilled", q.spilled)
+	}
+	q.w.Close()
+	q.r.Close()
+	os.Remove(q.path)
+}
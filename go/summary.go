@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"dataproc/workerpool"
+)
+
+// histogramBounds are the upper limits of the task duration buckets; a last,
+// open-ended bucket holds everything from the final bound up. They bracket
+// the 150-450ms spread of the simulated processor.
+var histogramBounds = []time.Duration{150 * time.Millisecond, 300 * time.Millisecond, 450 * time.Millisecond}
+
+// runStats is a workerpool.Observer that tallies task outcomes and a
+// histogram of task durations for the end-of-run summary. Like
+// progressReporter it only uses atomics, so workers never contend on a lock.
+type runStats struct {
+	tasks   atomic.Int64
+	failed  atomic.Int64
+	busy    atomic.Int64 // total per-task time in nanoseconds
+	buckets []atomic.Int64
+
+	// deadline and abandoned are set by cutOff when -deadline stopped the
+	// run.
+	deadline  time.Duration
+	abandoned int64
+}
+
+func newRunStats() *runStats {
+	return &runStats{buckets: make([]atomic.Int64, len(histogramBounds)+1)}
+}
+
+// TaskFinished implements workerpool.Observer.
+func (s *runStats) TaskFinished(r workerpool.Result, elapsed time.Duration) {
+	s.tasks.Add(1)
+	if r.Err != nil {
+		s.failed.Add(1)
+	}
+	s.busy.Add(int64(elapsed))
+
+	bucket := len(histogramBounds)
+	for i, bound := range histogramBounds {
+		if elapsed < bound {
+			bucket = i
+			break
+		}
+	}
+	s.buckets[bucket].Add(1)
+}
+
+// cutOff records that the run was stopped by its -deadline d, leaving
+// abandoned tasks unfinished. Call it after the pool has finished.
+func (s *runStats) cutOff(d time.Duration, abandoned int64) {
+	s.deadline, s.abandoned = d, abandoned
+}
+
+// bucketLabel names histogram bucket i, e.g. "<150ms", "150ms-300ms" or
+// ">=450ms".
+func bucketLabel(i int) string {
+	switch {
+	case i == 0:
+		return fmt.Sprintf("<%v", histogramBounds[0])
+	case i == len(histogramBounds):
+		return fmt.Sprintf(">=%v", histogramBounds[i-1])
+	default:
+		return fmt.Sprintf("%v-%v", histogramBounds[i-1], histogramBounds[i])
+	}
+}
+
+// runSummary is the snapshot printed at the end of a run; the JSON tags are
+// the keys of -summary=json.
+type runSummary struct {
+	Tasks       int64   `json:"tasks"`
+	Succeeded   int64   `json:"succeeded"`
+	Failed      int64   `json:"failed"`
+	WallSeconds float64 `json:"wall_seconds"`
+
+	// AvgTaskSecs is null without tasks, Throughput when the run took no
+	// measurable time (see perSecond); both are "n/a" in the text summary.
+	AvgTaskSecs *float64 `json:"avg_task_seconds"`
+	Throughput  *float64 `json:"throughput_per_second"`
+
+	// DeadlineExceeded is set when -deadline cut the run off, with the
+	// number of tasks still queued or in progress at that point.
+	DeadlineExceeded bool  `json:"deadline_exceeded,omitempty"`
+	Abandoned        int64 `json:"abandoned,omitempty"`
+
+	Histogram []bucketSummary `json:"histogram"`
+	Workers   []workerSummary `json:"workers,omitempty"`
+	Outputs   []outputSummary `json:"outputs,omitempty"`
+}
+
+// outputSummary is one output file and the SHA-256 of the bytes written to
+// it, in -summary=json.
+type outputSummary struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// bucketSummary is one task duration bucket in -summary=json.
+type bucketSummary struct {
+	Bucket string `json:"bucket"`
+	Count  int64  `json:"count"`
+}
+
+// workerSummary is one worker's share of the run in -summary=json.
+type workerSummary struct {
+	ID          int     `json:"id"`
+	Tasks       int     `json:"tasks"`
+	BusySeconds float64 `json:"busy_seconds"`
+}
+
+// snapshot computes the summary of a run that went from started to ended,
+// the times taken around Pool.Run, which give the wall time and throughput.
+// It is meant to be called once the pool has finished, when the counters no
+// longer change, and gives the same result however often it is called.
+func (s *runStats) snapshot(started, ended time.Time) runSummary {
+	wall := ended.Sub(started)
+	sum := runSummary{
+		Tasks:       s.tasks.Load(),
+		Failed:      s.failed.Load(),
+		WallSeconds: wall.Seconds(),
+
+		DeadlineExceeded: s.deadline > 0,
+		Abandoned:        s.abandoned,
+	}
+	sum.Succeeded = sum.Tasks - sum.Failed
+	for i := range s.buckets {
+		sum.Histogram = append(sum.Histogram, bucketSummary{Bucket: bucketLabel(i), Count: s.buckets[i].Load()})
+	}
+	if sum.Tasks > 0 {
+		avg := time.Duration(s.busy.Load() / sum.Tasks).Seconds()
+		sum.AvgTaskSecs = &avg
+	}
+	if rate, ok := perSecond(sum.Tasks, wall); ok {
+		sum.Throughput = &rate
+	}
+	return sum
+}
+
+// perSecond returns n per second of d. No tasks is a rate of 0 however
+// long the run took. Otherwise it returns false if d is zero or negative
+// (a clock too coarse to time a very short run, or stepped back), where the
+// division would give +Inf or a meaningless number.
+func perSecond(n int64, d time.Duration) (float64, bool) {
+	if n == 0 {
+		return 0, true
+	}
+	if d <= 0 {
+		return 0, false
+	}
+	return float64(n) / d.Seconds(), true
+}
+
+// formatRate renders n per d as e.g. "12.3" followed by unit, or "n/a"
+// when perSecond cannot tell.
+func formatRate(n int64, d time.Duration, unit string) string {
+	rate, ok := perSecond(n, d)
+	if !ok {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%s", rate, unit)
+}
+
+// print writes the summary to out as a readable block, or as a single JSON
+// object when asJSON is set. workers, from Pool.WorkerStats, adds one line
+// per worker so an unbalanced load (e.g. a worker stuck on slow payloads)
+// stands out. outputs, from Pool.OutputFiles, adds the digest of each output
+// file. started and ended are passed on to snapshot.
+func (s *runStats) print(out io.Writer, asJSON bool, started, ended time.Time, workers []workerpool.WorkerStats, outputs []workerpool.OutputFile) error {
+	sum := s.snapshot(started, ended)
+	for _, w := range workers {
+		sum.Workers = append(sum.Workers, workerSummary{ID: w.ID, Tasks: w.Tasks, BusySeconds: w.Busy.Seconds()})
+	}
+	for _, o := range outputs {
+		sum.Outputs = append(sum.Outputs, outputSummary{Path: o.Path, SHA256: o.SHA256})
+	}
+	if asJSON {
+		// Keep bucket labels such as "<150ms" readable.
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		return enc.Encode(sum)
+	}
+	avg, throughput := "n/a", "n/a"
+	if sum.AvgTaskSecs != nil {
+		avg = time.Duration(*sum.AvgTaskSecs * float64(time.Second)).Round(time.Millisecond).String()
+	}
+	if sum.Throughput != nil {
+		throughput = fmt.Sprintf("%.1f tasks/s", *sum.Throughput)
+	}
+	_, err := fmt.Fprintf(out, `Run summary:
+  tasks:        %d
+  succeeded:    %d
+  failed:       %d
+  wall time:    %v
+  avg per task: %s
+  throughput:   %s
+`, sum.Tasks, sum.Succeeded, sum.Failed,
+		time.Duration(sum.WallSeconds*float64(time.Second)).Round(time.Millisecond),
+		avg, throughput)
+	if err != nil {
+		return err
+	}
+	if sum.DeadlineExceeded {
+		if _, err := fmt.Fprintf(out, "  CUT OFF:      -deadline=%v exceeded, %d task(s) abandoned\n", s.deadline, sum.Abandoned); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(out, "  task durations:"); err != nil {
+		return err
+	}
+	for _, b := range sum.Histogram {
+		if _, err := fmt.Fprintf(out, "    %-12s %d\n", b.Bucket+":", b.Count); err != nil {
+			return err
+		}
+	}
+	if len(workers) > 0 {
+		if _, err := fmt.Fprintln(out, "  per worker:"); err != nil {
+			return err
+		}
+		for _, w := range workers {
+			if _, err := fmt.Fprintf(out, "    Worker-%d: %d tasks, %v\n", w.ID, w.Tasks, w.Busy.Round(time.Millisecond)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(outputs) > 0 {
+		if _, err := fmt.Fprintln(out, "  output sha256:"); err != nil {
+			return err
+		}
+		for _, o := range outputs {
+			if _, err := fmt.Fprintf(out, "    %s  %s\n", o.SHA256, o.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// newTracerProvider returns a provider that batches spans and exports them
+// over OTLP/HTTP to endpoint, either a URL (http://collector:4318) or a bare
+// host:port, which is reached over plain HTTP. Nothing is sent until the
+// first span ends, so an unreachable collector only shows up as export
+// errors, never as a failed run.
+func newTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	opt := otlptracehttp.WithEndpointURL(endpoint)
+	if !strings.Contains(endpoint, "://") {
+		opt = otlptracehttp.WithEndpointURL("http://" + endpoint)
+	}
+	exporter, err := otlptracehttp.New(ctx, opt)
+	if err != nil {
+		return nil, fmt.Errorf("-otel-endpoint: %w", err)
+	}
+	res := resource.NewSchemaless(attribute.String("service.name", "dataproc"))
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// shutdownTracing flushes the spans still batched in tp, waiting at most 5
+// seconds for the collector, so the end of the run is not lost on exit.
+func shutdownTracing(logger *slog.Logger, tp *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tp.Shutdown(ctx); err != nil {
+		logger.Error(fmt.Sprintf("failed to flush traces: %v", err))
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// transform rewrites a task payload before it is submitted.
+type transform func(string) string
+
+// transforms maps the names accepted by -transforms to their functions;
+// "prefix:TEXT" is handled separately by parseTransforms since it takes an
+// argument.
+var transforms = map[string]transform{
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// parseTransforms builds the pipeline for a comma-separated -transforms
+// value such as "trim,upper,prefix:job-". The transforms run in the order
+// given. An unknown name is an error, so typos are caught at startup rather
+// than silently leaving payloads unchanged.
+func parseTransforms(spec string) ([]transform, error) {
+	var pipeline []transform
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if text, ok := strings.CutPrefix(name, "prefix:"); ok {
+			pipeline = append(pipeline, func(s string) string { return text + s })
+			continue
+		}
+		t, ok := transforms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q (want trim, upper, lower or prefix:TEXT)", name)
+		}
+		pipeline = append(pipeline, t)
+	}
+	return pipeline, nil
+}
+
+// applyTransforms runs payload through pipeline.
+func applyTransforms(pipeline []transform, payload string) string {
+	for _, t := range pipeline {
+		payload = t(payload)
+	}
+	return payload
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+)
+
+// watched is the part of the pool the watchdog reads.
+type watched interface {
+	statser
+	Paused() bool
+}
+
+// watchdog turns a silent hang into a loud failure: if no task finished for
+// timeout while tasks are still queued or in flight, it logs an error with
+// the queue depth, in-flight count and goroutine count, then calls trip if
+// it is not nil (e.g. to dump the goroutines and exit). A run that makes any
+// progress, however slowly, never trips it, and neither does an idle one
+// with nothing left to do or one paused on purpose (SIGUSR1). It stops when
+// stop is closed, then closes finished.
+//
+// Without trip the watchdog keeps watching and reports each stall once: it
+// is rearmed when a task finishes again.
+func watchdog(logger *slog.Logger, pool watched, timeout time.Duration, trip func(), stop <-chan struct{}, finished chan<- struct{}) {
+	defer close(finished)
+
+	// Check often enough that a stall is reported close to timeout.
+	ticker := time.NewTicker(max(min(timeout/4, time.Second), time.Millisecond))
+	defer ticker.Stop()
+
+	var last int64
+	lastProgress := time.Now()
+	tripped := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s := pool.Stats()
+		done := s.Completed + s.Failed
+		if done != last || pool.Paused() || (s.QueueDepth == 0 && s.InFlight == 0) {
+			last, lastProgress, tripped = done, time.Now(), false
+			continue
+		}
+		if tripped || time.Since(lastProgress) < timeout {
+			continue
+		}
+		tripped = true
+		logger.Error(fmt.Sprintf("Watchdog: no task finished in %v: queue=%d in-flight=%d completed=%d failed=%d goroutines=%d",
+			timeout, s.QueueDepth, s.InFlight, s.Completed, s.Failed, runtime.NumGoroutine()),
+			"event", "WATCHDOG", "queue", s.QueueDepth, "in_flight", s.InFlight, "goroutines", runtime.NumGoroutine())
+		if trip != nil {
+			trip()
+		}
+	}
+}
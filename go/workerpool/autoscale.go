@@ -0,0 +1,77 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// scaleCheckInterval is how often the autoscaler samples the queue depth.
+	scaleCheckInterval = 100 * time.Millisecond
+
+	// scaleIdleTimeout is how long a worker added by the autoscaler may sit
+	// idle before it exits.
+	scaleIdleTimeout = time.Second
+)
+
+// autoscale is the supervisor goroutine used when the pool has a maxWorkers
+// limit or an idle timeout. Every scaleCheckInterval it checks the queue
+// depth and starts one more worker when either
+//   - tasks are queued and idle workers have retired (WithIdleTimeout), so
+//     the pool is below the numWorkers given to NewPool, or
+//   - the queue is at least three quarters full, up to maxWorkers in total.
+//
+// Added workers exit on their own after the idle timeout (scaleIdleTimeout
+// without WithIdleTimeout), which frees their slot again.
+//
+// The supervisor returns once every worker has exited (the queue is closed
+// and drained or ctx is cancelled). Workers are only added while at least one
+// is still running, so once it has returned wg.Wait is safe.
+func autoscale[T any](ctx context.Context, p *Pool[T], resultsChan chan<- Result, errorsChan chan<- error, wg *sync.WaitGroup) {
+	ticker := time.NewTicker(scaleCheckInterval)
+	defer ticker.Stop()
+
+	idleTimeout := p.idleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = scaleIdleTimeout
+	}
+	nextID := p.numWorkers + 1
+
+	for {
+		select {
+		case <-p.allExited:
+			return
+		case <-ticker.C:
+			// A paused pool is meant to have work piling up.
+			if p.Paused() {
+				continue
+			}
+			depth, capacity := p.queueDepth()
+			live := p.live.Load()
+
+			var reason string
+			switch {
+			case p.idleTimeout > 0 && depth > 0 && int(live) < p.numWorkers:
+				reason = "replacing an idle worker"
+			case int(live) < p.maxWorkers && capacity > 0 && depth*4 >= capacity*3:
+				reason = "queue is deep"
+			default:
+				continue
+			}
+			if !p.tryAddWorker(live) {
+				// A worker started or exited meanwhile; check again next tick.
+				continue
+			}
+
+			p.logger.Info(fmt.Sprintf("Autoscaler: queue %d/%d, %s, starting Worker-%d", depth, capacity, reason, nextID),
+				"event", "SCALE_UP", "worker", nextID)
+			stats := &WorkerStats{ID: nextID}
+			p.workerStats = append(p.workerStats, stats)
+			wg.Add(1)
+			go worker(ctx, p, nextID, stats, idleTimeout, resultsChan, errorsChan, wg)
+			nextID++
+		}
+	}
+}
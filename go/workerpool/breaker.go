@@ -0,0 +1,95 @@
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is the Result error of a task that was failed without being
+// processed because the circuit breaker (WithCircuitBreaker) was open.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a circuit breaker shared by all workers. It is closed while
+// processing works; threshold consecutive failed attempts open it, and while
+// it is open every attempt fails fast with ErrBreakerOpen instead of reaching
+// the Processor. After cooldown it half-opens and lets a single attempt
+// through as a probe: success closes it again, failure reopens it for
+// another cooldown.
+//
+// Every worker calls allow and record, so all state is guarded by mu. A nil
+// *breaker allows everything.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration, logger *slog.Logger) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, logger: logger}
+}
+
+// allow reports whether an attempt may run now. Once the cooldown has
+// passed, the first caller gets the half-open probe and everyone else is
+// still refused until its outcome is recorded.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.logger.Info("Circuit breaker HALF-OPEN: probing with one task", "event", "BREAKER_HALF_OPEN")
+		return true
+	case breakerHalfOpen:
+		return false
+	}
+	return true
+}
+
+// record counts the outcome of an attempt that allow let through.
+func (b *breaker) record(ok bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		if b.state != breakerClosed {
+			b.logger.Info("Circuit breaker CLOSED: probe succeeded", "event", "BREAKER_CLOSED")
+		}
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	switch {
+	case b.state == breakerHalfOpen:
+		b.state, b.openedAt = breakerOpen, time.Now()
+		b.logger.Warn(fmt.Sprintf("Circuit breaker OPEN: probe failed, failing tasks fast for %v", b.cooldown), "event", "BREAKER_OPEN")
+	case b.state == breakerClosed && b.failures >= b.threshold:
+		b.state, b.openedAt = breakerOpen, time.Now()
+		b.logger.Warn(fmt.Sprintf("Circuit breaker OPEN after %d consecutive failures, failing tasks fast for %v", b.failures, b.cooldown), "event", "BREAKER_OPEN")
+	}
+}
@@ -0,0 +1,90 @@
+package workerpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// byteBudget caps the payload bytes of the tasks submitted and not yet
+// processed (see WithMaxInflightBytes). used is updated under mu but read
+// without it by Stats.
+type byteBudget struct {
+	max  int64
+	used atomic.Int64
+
+	// freed is closed and replaced on every release, waking all the
+	// submitters waiting for room at once.
+	mu    sync.Mutex
+	freed chan struct{}
+}
+
+func newByteBudget(max int64) *byteBudget {
+	return &byteBudget{max: max, freed: make(chan struct{})}
+}
+
+// tryAcquire takes n bytes if they fit and reports whether it did. A task
+// always fits while nothing is in flight, so one payload larger than the
+// whole budget still gets through instead of blocking forever.
+func (b *byteBudget) tryAcquire(n int64) (ok bool, freed <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if used := b.used.Load(); used == 0 || used+n <= b.max {
+		b.used.Add(n)
+		return true, nil
+	}
+	return false, b.freed
+}
+
+// acquire takes n bytes, waiting for workers to release enough of them. It
+// gives up with ctx.Err() when ctx is done, or with ErrPoolClosed when
+// closing is closed.
+func (b *byteBudget) acquire(ctx context.Context, closing <-chan struct{}, n int64) error {
+	for {
+		ok, freed := b.tryAcquire(n)
+		if ok {
+			return nil
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-closing:
+			return ErrPoolClosed
+		}
+	}
+}
+
+// release gives back n bytes and wakes the waiting submitters. It is a
+// no-op on a nil budget, so workers need not check whether one is set.
+func (b *byteBudget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used.Add(-n)
+	close(b.freed)
+	b.freed = make(chan struct{})
+	b.mu.Unlock()
+}
+
+// inUse reports the bytes currently accounted; 0 on a nil budget.
+func (b *byteBudget) inUse() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+// payloadSize is what a task counts against WithMaxInflightBytes: the
+// length of a string or []byte payload. Other payload types count as 0
+// bytes, so the limit has no effect on them.
+func payloadSize(v any) int64 {
+	switch p := v.(type) {
+	case string:
+		return int64(len(p))
+	case []byte:
+		return int64(len(p))
+	}
+	return 0
+}
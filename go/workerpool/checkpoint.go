@@ -0,0 +1,91 @@
+package workerpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// checkpointFile is the on-disk layout of a checkpoint.
+type checkpointFile struct {
+	Completed []int `json:"completed"`
+}
+
+// LoadCheckpoint reads the set of completed task IDs from a checkpoint file
+// written by a previous run with WithCheckpoint. A missing file returns an
+// error satisfying errors.Is(err, os.ErrNotExist); callers usually start
+// fresh in that case, as they would for a corrupt file.
+func LoadCheckpoint(path string) (map[int]struct{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cf checkpointFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("corrupt checkpoint '%s': %w", path, err)
+	}
+	done := make(map[int]struct{}, len(cf.Completed))
+	for _, id := range cf.Completed {
+		done[id] = struct{}{}
+	}
+	return done, nil
+}
+
+// checkpoint tracks the IDs of successfully processed tasks whose output has
+// been flushed, and saves them to a file for LoadCheckpoint. It is shared by
+// every writer goroutine when sharding, so it is guarded by a mutex.
+type checkpoint struct {
+	path string
+
+	mu    sync.Mutex
+	done  map[int]struct{}
+	dirty bool
+}
+
+func newCheckpoint(path string, done map[int]struct{}) *checkpoint {
+	c := &checkpoint{path: path, done: make(map[int]struct{}, len(done))}
+	maps.Copy(c.done, done)
+	return c
+}
+
+// markDone records ids as completed. Only call it once their output lines
+// have been flushed, so a crash never skips a task whose result was lost.
+func (c *checkpoint) markDone(ids []int) {
+	if len(ids) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		c.done[id] = struct{}{}
+	}
+	c.dirty = true
+}
+
+// save writes the completed IDs to the checkpoint file if they changed since
+// the last save. It writes a temporary file and renames it over the old one,
+// so a crash mid-save leaves the previous checkpoint intact.
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(checkpointFile{Completed: slices.Sorted(maps.Keys(c.done))})
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,170 @@
+package workerpool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrDependencyFailed is the Result error of a task that was never processed
+// because one of its DependsOn tasks failed, expired or was itself skipped.
+var ErrDependencyFailed = errors.New("dependency failed")
+
+// completion tells the DAG dispatcher that a worker has finished a task and
+// whether it succeeded.
+type completion struct {
+	taskID int
+	ok     bool
+}
+
+// checkDependencies validates the dependency graph of jobs before anything
+// runs: task IDs must be unique, every DependsOn ID must name a submitted
+// task, and there must be no cycle. The cycle error lists the tasks on it,
+// e.g. "dependency cycle: Task-1 -> Task-3 -> Task-1".
+func checkDependencies[T any](jobs []job[T]) error {
+	byID := make(map[int]job[T], len(jobs))
+	for _, j := range jobs {
+		if _, dup := byID[j.task.ID]; dup {
+			return fmt.Errorf("duplicate task ID %d", j.task.ID)
+		}
+		byID[j.task.ID] = j
+	}
+	for _, j := range jobs {
+		for _, dep := range j.task.DependsOn {
+			if _, ok := byID[dep]; !ok {
+				return fmt.Errorf("Task-%d depends on unknown Task-%d", j.task.ID, dep)
+			}
+		}
+	}
+
+	// Depth-first search; a dependency that is still on the stack (visiting)
+	// closes a cycle.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[int]int, len(jobs))
+	var stack []int
+	var visit func(id int) error
+	visit = func(id int) error {
+		switch state[id] {
+		case visiting:
+			cycle := append(stack[slices.Index(stack, id):], id)
+			names := make([]string, len(cycle))
+			for i, c := range cycle {
+				names[i] = fmt.Sprintf("Task-%d", c)
+			}
+			return fmt.Errorf("dependency cycle: %s", strings.Join(names, " -> "))
+		case visited:
+			return nil
+		}
+		state[id] = visiting
+		stack = append(stack, id)
+		for _, dep := range byID[id].task.DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+		return nil
+	}
+	for _, j := range jobs {
+		if err := visit(j.task.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dagDispatch is the dispatcher used with WithDependencies. It first loads
+// every submitted job from p.incoming until Close, then checks the
+// dependency graph with checkDependencies; if that fails, nothing is handed
+// out and the error is returned, so Run refuses to process anything.
+//
+// Otherwise jobs are released to the (unbuffered) p.tasks channel once all
+// their dependencies have completed, highest Priority first among those that
+// are ready. Workers report each finished task on p.completions. The
+// dependents of a task that did not succeed are handed out marked with
+// ErrDependencyFailed, so workers record them as failed without processing
+// them, and the failure cascades.
+//
+// dagDispatch closes p.tasks once every job has been handed out, or when ctx
+// is cancelled, and then p.dagDone, so workers stop reporting completions
+// nobody waits for anymore.
+func dagDispatch[T any](ctx context.Context, p *Pool[T]) error {
+	defer close(p.dagDone)
+	defer close(p.tasks)
+
+	var jobs []job[T]
+	for loading := true; loading; {
+		select {
+		case <-ctx.Done():
+			return nil
+		case j, ok := <-p.incoming:
+			if !ok {
+				loading = false
+				break
+			}
+			jobs = append(jobs, j)
+		}
+	}
+	if err := checkDependencies(jobs); err != nil {
+		p.queued.Add(-int64(len(jobs)))
+		return fmt.Errorf("refusing to start: %w", err)
+	}
+
+	// waiting counts the unfinished dependencies of each job; dependents
+	// lists, per task ID, the jobs waiting on it. failedDep records the
+	// first unsuccessful dependency of a job.
+	waiting := make(map[int]int, len(jobs))
+	dependents := make(map[int][]job[T], len(jobs))
+	failedDep := make(map[int]int)
+	var ready jobHeap[T]
+	for _, j := range jobs {
+		deps := slices.Compact(slices.Sorted(slices.Values(j.task.DependsOn)))
+		waiting[j.task.ID] = len(deps)
+		for _, dep := range deps {
+			dependents[dep] = append(dependents[dep], j)
+		}
+		if len(deps) == 0 {
+			heap.Push(&ready, j)
+		}
+	}
+
+	handedOut := 0
+	for handedOut < len(jobs) {
+		// send stays nil (never ready) while no job is ready.
+		var send chan<- job[T]
+		var next job[T]
+		if ready.Len() > 0 {
+			send, next = p.tasks, ready[0]
+			if dep, ok := failedDep[next.task.ID]; ok {
+				next.skip = fmt.Errorf("%w: Task-%d did not succeed", ErrDependencyFailed, dep)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case send <- next:
+			heap.Pop(&ready)
+			p.queued.Add(-1)
+			handedOut++
+		case c := <-p.completions:
+			for _, d := range dependents[c.taskID] {
+				if _, seen := failedDep[d.task.ID]; !c.ok && !seen {
+					failedDep[d.task.ID] = c.taskID
+				}
+				if waiting[d.task.ID]--; waiting[d.task.ID] == 0 {
+					heap.Push(&ready, d)
+				}
+			}
+		}
+	}
+	return nil
+}
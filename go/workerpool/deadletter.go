@@ -0,0 +1,59 @@
+package workerpool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// deadLetter is the JSON Lines record written for each permanently failed
+// task. It carries enough to re-submit the task later.
+type deadLetter struct {
+	ID       int    `json:"id"`
+	Payload  any    `json:"payload"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+
+	// ErrorType is the kind of failure (see errorType), for filtering
+	// without matching the message.
+	ErrorType string `json:"error_type,omitempty"`
+}
+
+// deadLetterWriter owns the dead-letter file at path, in the same way writer
+// owns the output file: it is the only goroutine writing it. It writes one
+// deadLetter record per failure until failures is closed.
+//
+// The file is created (truncated) by Run before any worker starts, so a run
+// without failures leaves an empty file rather than a stale one from an
+// earlier run. As with writer, the first write/flush/close error is returned
+// and later ones are logged.
+func deadLetterWriter(file *os.File, logger *slog.Logger, failures <-chan *taskFailure) (err error) {
+	fail := func(e error) {
+		if err == nil {
+			err = e
+			return
+		}
+		logger.Error(e.Error())
+	}
+
+	buf := bufio.NewWriter(file)
+	defer func() {
+		if ferr := buf.Flush(); ferr != nil {
+			fail(fmt.Errorf("failed to flush dead-letter file: %w", ferr))
+		}
+		if cerr := file.Close(); cerr != nil {
+			fail(fmt.Errorf("failed to close dead-letter file: %w", cerr))
+		}
+	}()
+
+	enc := json.NewEncoder(buf)
+	for f := range failures {
+		rec := deadLetter{ID: f.taskID, Payload: f.payload, Attempts: f.attempts, Error: f.err.Error(), ErrorType: errorType(f.err)}
+		if werr := enc.Encode(rec); werr != nil && err == nil {
+			fail(fmt.Errorf("failed to write dead-letter record: %w", werr))
+		}
+	}
+	return err
+}
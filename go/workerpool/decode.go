@@ -0,0 +1,100 @@
+package workerpool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PayloadEncoding is how individual string payloads are encoded, e.g. to
+// carry compressed binary blobs through line-based input.
+type PayloadEncoding string
+
+const (
+	// EncodingNone leaves payloads as they are (the default).
+	EncodingNone PayloadEncoding = "none"
+
+	// EncodingBase64Gzip marks payloads that are gzip-compressed and then
+	// base64-encoded (standard alphabet, with padding), as produced by
+	// `gzip -c | base64 -w0`.
+	EncodingBase64Gzip PayloadEncoding = "base64-gzip"
+)
+
+// ParsePayloadEncoding validates an encoding name such as the value of a
+// -payload-encoding flag.
+func ParsePayloadEncoding(s string) (PayloadEncoding, error) {
+	switch e := PayloadEncoding(s); e {
+	case EncodingNone, EncodingBase64Gzip:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown payload encoding %q (want %q or %q)", s, EncodingNone, EncodingBase64Gzip)
+}
+
+// ErrPayloadDecode wraps the error of a payload that could not be decoded
+// (see DecodePayloads). Such tasks are not retried, since decoding the same
+// bytes again fails the same way, and do not count as failures for the
+// circuit breaker, since they say nothing about the downstream.
+var ErrPayloadDecode = errors.New("payload decode failed")
+
+// maxDecodedSize caps a decoded payload, so a small, highly compressed
+// payload (a gzip bomb) cannot exhaust memory.
+const maxDecodedSize = 64 << 20
+
+// DecodePayloads returns a Processor that decodes each task's payload from
+// enc and passes the task on to next with the decoded payload. A malformed
+// payload, or one that decodes to more than 64 MiB, fails the task with an
+// error wrapping ErrPayloadDecode without calling next, so it ends up in the
+// WithDeadLetter file with its original, still encoded payload. With
+// EncodingNone, next itself is returned.
+func DecodePayloads(next Processor[string], enc PayloadEncoding) Processor[string] {
+	if enc == EncodingNone || enc == "" {
+		return next
+	}
+	return &decodingProcessor{next: next, enc: enc}
+}
+
+type decodingProcessor struct {
+	next Processor[string]
+	enc  PayloadEncoding
+}
+
+func (d *decodingProcessor) Process(ctx context.Context, t StringTask) (string, error) {
+	payload, err := decodeBase64Gzip(t.Payload, maxDecodedSize)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPayloadDecode, err)
+	}
+	t.Payload = payload
+	return d.next.Process(ctx, t)
+}
+
+// Init passes the options on to the wrapped Processor (see InitProcessor).
+func (d *decodingProcessor) Init(opts json.RawMessage) error {
+	return InitProcessor(d.next, opts)
+}
+
+// decodeBase64Gzip decodes s from EncodingBase64Gzip, failing once the
+// result grows beyond max bytes. Surrounding whitespace is ignored.
+func decodeBase64Gzip(s string, max int64) (string, error) {
+	compressed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", fmt.Errorf("invalid gzip: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(zr, max+1))
+	if err != nil {
+		return "", fmt.Errorf("invalid gzip: %w", err)
+	}
+	if int64(len(data)) > max {
+		return "", fmt.Errorf("decoded payload is over the %d-byte limit", max)
+	}
+	return string(data), nil
+}
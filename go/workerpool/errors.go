@@ -0,0 +1,113 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The typed errors below are the Result.Err (and dead-letter error) of a
+// task that failed in its Processor, wrapped in further context where
+// needed, so library callers can tell the causes apart with errors.As
+// instead of matching log text:
+//
+//	var te *workerpool.TaskTimeoutError[string]
+//	if errors.As(r.Err, &te) {
+//		log.Printf("Task-%d needs more than %v", te.Task.ID, te.Timeout)
+//	}
+//
+// Only the last attempt's error is kept when a task fails after retries.
+
+// TaskTimeoutError is the error of a processing attempt that ran past the
+// WithTaskTimeout limit. It matches context.DeadlineExceeded with
+// errors.Is.
+type TaskTimeoutError[T any] struct {
+	Task    Task[T]
+	Timeout time.Duration
+}
+
+func (e *TaskTimeoutError[T]) Error() string {
+	return fmt.Sprintf("timed out after %v: %v", e.Timeout, context.DeadlineExceeded)
+}
+
+func (e *TaskTimeoutError[T]) Unwrap() error { return context.DeadlineExceeded }
+
+func (e *TaskTimeoutError[T]) errorType() string { return "timeout" }
+
+// TaskPanicError is the error of a processing attempt whose Processor
+// panicked. The panic was recovered in the worker; Value is what was passed
+// to panic and Stack the goroutine's stack at that point. Such tasks are not
+// retried, since a panic usually reproduces on every try. The message is a
+// single line with the panic value, so it fits output lines and the
+// dead-letter file; the stack is only logged (event PANICKED) and kept in
+// Stack.
+type TaskPanicError[T any] struct {
+	Task  Task[T]
+	Value any
+	Stack []byte
+}
+
+func (e *TaskPanicError[T]) Error() string {
+	return fmt.Sprintf("%v: %v", errPanicked, e.Value)
+}
+
+func (e *TaskPanicError[T]) Unwrap() error { return errPanicked }
+
+func (e *TaskPanicError[T]) errorType() string { return "panic" }
+
+// ProcessorError is any other error a Processor returned for Task; Err is
+// that error, unchanged, and its message is the error's message.
+type ProcessorError[T any] struct {
+	Task Task[T]
+	Err  error
+}
+
+func (e *ProcessorError[T]) Error() string { return e.Err.Error() }
+
+func (e *ProcessorError[T]) Unwrap() error { return e.Err }
+
+func (e *ProcessorError[T]) errorType() string { return "processor" }
+
+// typedError is implemented by the typed errors above, whatever their
+// payload type.
+type typedError interface {
+	error
+	errorType() string
+}
+
+// errorType names the kind of a task failure for the dead-letter file:
+// "decode" for a payload DecodePayloads could not decode, "timeout",
+// "panic" or "processor" for the typed errors, "invalid", "breaker_open" or
+// "dependency_failed" for tasks the pool refused to process, and "" for
+// anything else.
+func errorType(err error) string {
+	var te typedError
+	switch {
+	case errors.Is(err, ErrPayloadDecode):
+		return "decode"
+	case errors.As(err, &te):
+		return te.errorType()
+	case errors.Is(err, ErrInvalidTask):
+		return "invalid"
+	case errors.Is(err, ErrBreakerOpen):
+		return "breaker_open"
+	case errors.Is(err, ErrDependencyFailed):
+		return "dependency_failed"
+	}
+	return ""
+}
+
+// classify turns the error of a processing attempt of task into one of the
+// typed errors. attemptCtx is the attempt's context, limited by timeout; an
+// error after ctx, the run's context, was cancelled is returned as is, as
+// that is not the task's fault.
+func classify[T any](ctx, attemptCtx context.Context, task Task[T], timeout time.Duration, err error) error {
+	switch {
+	case err == nil || ctx.Err() != nil:
+		return err
+	case timeout > 0 && errors.Is(attemptCtx.Err(), context.DeadlineExceeded):
+		return &TaskTimeoutError[T]{Task: task, Timeout: timeout}
+	}
+	return &ProcessorError[T]{Task: task, Err: err}
+}
@@ -0,0 +1,30 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type panickingProcessor struct{}
+
+func (panickingProcessor) Process(context.Context, StringTask) (string, error) {
+	panic("boom")
+}
+
+// TestTaskPanicErrorOneLine checks that a recovered panic gives a one-line
+// error message while the stack stays available in the error.
+func TestTaskPanicErrorOneLine(t *testing.T) {
+	_, err := processTask[string](context.Background(), quietLogger(), panickingProcessor{}, StringTask{ID: 7}, 0)
+	var pe *TaskPanicError[string]
+	if !errors.As(err, &pe) {
+		t.Fatalf("processTask() = %v, want a TaskPanicError", err)
+	}
+	if got, want := err.Error(), "processor panicked: boom"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !strings.Contains(string(pe.Stack), "panickingProcessor") {
+		t.Errorf("Stack does not show the panicking Processor:\n%s", pe.Stack)
+	}
+}
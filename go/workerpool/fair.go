@@ -0,0 +1,80 @@
+package workerpool
+
+import "container/heap"
+
+// fairQueue is the jobQueue of WithFairScheduling, a weighted fair queue.
+// Jobs are grouped into one flow per Task weight, and every flow gets an
+// equal share of the total weight handed out over time: a flow's tag grows
+// by the weight of each job it hands out, and the flow with the smallest
+// tag goes next. Twenty weight-1 tasks are thus handed out for every two
+// weight-10 ones, so a few heavy tasks cannot take every worker while many
+// light ones wait, and the light ones cannot starve the heavy ones either.
+// Within a flow, jobs go by priority, then in submission order.
+type fairQueue[T any] struct {
+	flows map[int]*fairFlow[T]
+	n     int
+
+	// vtime is the tag of the flow that went last. A flow that was empty
+	// starts again no earlier than that, so it cannot claim the share it
+	// did not use while idle.
+	vtime int64
+
+	// next is the flow chosen by peek, which pop takes the job from.
+	next *fairFlow[T]
+}
+
+// fairFlow is the queue of one weight.
+type fairFlow[T any] struct {
+	weight int
+	jobs   jobHeap[T]
+	tag    int64
+}
+
+func newFairQueue[T any]() *fairQueue[T] {
+	return &fairQueue[T]{flows: make(map[int]*fairFlow[T])}
+}
+
+// taskWeight is the scheduling weight of t: Task.Weight, or 1 if it is
+// unset or not positive.
+func taskWeight[T any](t Task[T]) int {
+	return max(t.Weight, 1)
+}
+
+func (q *fairQueue[T]) push(j job[T]) {
+	w := taskWeight(j.task)
+	f := q.flows[w]
+	if f == nil {
+		f = &fairFlow[T]{weight: w}
+		q.flows[w] = f
+	}
+	if f.jobs.Len() == 0 {
+		f.tag = max(f.tag, q.vtime)
+	}
+	heap.Push(&f.jobs, j)
+	q.n++
+}
+
+// peek picks the non-empty flow with the smallest tag; on a tie, the one
+// whose next job was submitted first.
+func (q *fairQueue[T]) peek() job[T] {
+	q.next = nil
+	for _, f := range q.flows {
+		if f.jobs.Len() == 0 {
+			continue
+		}
+		if n := q.next; n == nil || f.tag < n.tag || (f.tag == n.tag && f.jobs[0].seq < n.jobs[0].seq) {
+			q.next = f
+		}
+	}
+	return q.next.jobs[0]
+}
+
+func (q *fairQueue[T]) pop() {
+	f := q.next
+	heap.Pop(&f.jobs)
+	q.n--
+	q.vtime = f.tag
+	f.tag += int64(f.weight)
+}
+
+func (q *fairQueue[T]) len() int { return q.n }
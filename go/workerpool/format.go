@@ -0,0 +1,200 @@
+package workerpool
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of processing one Task. Workers send Results to the
+// writer, which owns serialization, so the output format can change without
+// touching worker code.
+type Result struct {
+	TaskID    int
+	WorkerID  int
+	Payload   string
+	Timestamp time.Time
+
+	// Err is the last processing error after all retries, or nil on success.
+	// For a failed task Payload holds the original input payload.
+	Err error
+
+	// Attempts is the number of processing attempts that produced the result
+	// (1 = first try); 0 for a task that expired or was skipped unprocessed.
+	Attempts int
+
+	// Duration is the time the worker spent on the task, including retries
+	// and backoff.
+	Duration time.Duration
+
+	// Meta is the Meta of the task, shared rather than copied.
+	Meta map[string]string
+
+	// seq is the submission sequence number of the task (see job).
+	seq int
+}
+
+// Format selects how the writer serializes Results.
+type Format string
+
+const (
+	// FormatText is the default human-readable layout, identical to the
+	// original output and to the Java implementation:
+	//	[timestamp] Worker-N processed Task-M payload='...'
+	FormatText Format = "text"
+
+	// FormatJSON emits one JSON object per line (JSON Lines) with the fields
+	// id, worker, payload and timestamp (plus error for failed tasks, meta
+	// for tasks with metadata, and attempts and duration_ms when verbose).
+	FormatJSON Format = "json"
+
+	// FormatCSV emits a header row followed by one CSV record per result,
+	// with the columns id, worker, payload and timestamp (plus attempts,
+	// duration_ms and meta when verbose). Quoting of commas, quotes and
+	// newlines in payloads is handled by encoding/csv.
+	FormatCSV Format = "csv"
+)
+
+// csvHeader returns the first row of every FormatCSV file.
+func csvHeader(verbose bool) []string {
+	header := []string{"id", "worker", "payload", "timestamp"}
+	if verbose {
+		header = append(header, "attempts", "duration_ms", "meta")
+	}
+	return header
+}
+
+// ParseFormat validates a format name such as the value of a -format flag.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatText, FormatJSON, FormatCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (want %q, %q or %q)", s, FormatText, FormatJSON, FormatCSV)
+}
+
+// jsonResult is the JSON Lines representation of a Result.
+type jsonResult struct {
+	ID        int       `json:"id"`
+	Worker    int       `json:"worker"`
+	Payload   string    `json:"payload"`
+	Timestamp time.Time `json:"timestamp"`
+	Error     string    `json:"error,omitempty"`
+
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Only set when verbose.
+	Attempts   *int     `json:"attempts,omitempty"`
+	DurationMS *float64 `json:"duration_ms,omitempty"`
+}
+
+// durationMS converts d to fractional milliseconds for the JSON and CSV
+// duration_ms fields.
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// formatResult renders r as a single newline-terminated line in format f.
+// With verbose, the attempt count and duration of the task are included.
+func formatResult(f Format, verbose bool, r Result) (string, error) {
+	switch f {
+	case FormatJSON:
+		jr := jsonResult{ID: r.TaskID, Worker: r.WorkerID, Payload: r.Payload, Timestamp: r.Timestamp, Meta: r.Meta}
+		if r.Err != nil {
+			jr.Error = r.Err.Error()
+		}
+		if verbose {
+			ms := durationMS(r.Duration)
+			jr.Attempts, jr.DurationMS = &r.Attempts, &ms
+		}
+		b, err := json.Marshal(jr)
+		if err != nil {
+			return "", err
+		}
+		return string(b) + "\n", nil
+	case FormatCSV:
+		record := []string{
+			strconv.Itoa(r.TaskID),
+			strconv.Itoa(r.WorkerID),
+			r.Payload,
+			r.Timestamp.Format(time.RFC3339Nano),
+		}
+		if verbose {
+			record = append(record, strconv.Itoa(r.Attempts), strconv.FormatFloat(durationMS(r.Duration), 'f', 3, 64), formatMeta(r.Meta))
+		}
+		return formatCSV(record)
+	default:
+		return formatText(r, verbose), nil
+	}
+}
+
+// formatMeta renders task metadata as key=value pairs separated by ";", in
+// key order, e.g. "tenant=acme;trace=abc".
+func formatMeta(meta map[string]string) string {
+	var sb strings.Builder
+	for i, k := range slices.Sorted(maps.Keys(meta)) {
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+		sb.WriteString(k + "=" + meta[k])
+	}
+	return sb.String()
+}
+
+// formatCSV renders one CSV record, quoted as needed. The csv.Writer is
+// flushed and its error checked before the record is returned.
+func formatCSV(record []string) (string, error) {
+	var sb strings.Builder
+	cw := csv.NewWriter(&sb)
+	if err := cw.Write(record); err != nil {
+		return "", err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// formatText renders r in the FormatText layout. Failed tasks are marked
+// TIMEOUT or FAILED, dropped ones EXPIRED, those whose dependency failed
+// SKIPPED and those failed fast by the circuit breaker REJECTED; tasks that
+// needed retries end with attempt=N. With verbose, every line ends with
+// attempts=N duration=D instead, followed by meta=k=v;... for a task with
+// metadata.
+func formatText(r Result, verbose bool) string {
+	status := fmt.Sprintf("Worker-%d processed Task-%d", r.WorkerID, r.TaskID)
+	if r.Err != nil {
+		label := "FAILED"
+		switch {
+		case errors.Is(r.Err, ErrExpired):
+			label = "EXPIRED"
+		case errors.Is(r.Err, ErrDependencyFailed):
+			label = "SKIPPED"
+		case errors.Is(r.Err, ErrBreakerOpen):
+			label = "REJECTED"
+		case errors.Is(r.Err, context.DeadlineExceeded):
+			label = "TIMEOUT"
+		}
+		status = fmt.Sprintf("%s Worker-%d Task-%d", label, r.WorkerID, r.TaskID)
+	}
+
+	line := fmt.Sprintf("[%s] %s payload='%s'", r.Timestamp.Format(time.RFC3339Nano), status, r.Payload)
+	switch {
+	case verbose:
+		line += fmt.Sprintf(" attempts=%d duration=%v", r.Attempts, r.Duration.Round(time.Microsecond))
+		if len(r.Meta) > 0 {
+			line += " meta=" + formatMeta(r.Meta)
+		}
+	case r.Attempts > 1:
+		line += fmt.Sprintf(" attempt=%d", r.Attempts)
+	}
+	return line + "\n"
+}
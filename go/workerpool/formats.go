@@ -0,0 +1,124 @@
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// formatExt is the file extension of each format's file when several
+// formats are written at once.
+var formatExt = map[Format]string{
+	FormatText: ".txt",
+	FormatJSON: ".json",
+	FormatCSV:  ".csv",
+}
+
+// ParseFormats validates a comma-separated list of formats such as the
+// value "text,json" of a -format flag. A format may only be listed once.
+func ParseFormats(s string) ([]Format, error) {
+	var formats []Format
+	for _, name := range strings.Split(s, ",") {
+		f, err := ParseFormat(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(formats, f) {
+			return nil, fmt.Errorf("output format %q listed twice", f)
+		}
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
+
+// formatPath derives the file of format f from the base output path by
+// replacing its extension (keeping a trailing ".gz"), e.g.
+// target/go-output.txt -> target/go-output.json.
+func formatPath(base string, f Format) string {
+	gz := ""
+	if strings.HasSuffix(base, ".gz") {
+		gz = ".gz"
+		base = strings.TrimSuffix(base, gz)
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base)) + formatExt[f] + gz
+}
+
+// perFormat returns the configuration of each format's lineSink. Only the
+// first one keeps the checkpoint, so task IDs are recorded once, as soon as
+// they are flushed to the first format's file.
+func (cfg outputConfig) perFormat() []outputConfig {
+	cfgs := make([]outputConfig, len(cfg.formats))
+	for i, f := range cfg.formats {
+		c := cfg
+		c.format, c.path = f, formatPath(cfg.path, f)
+		if i > 0 {
+			c.checkpoint = nil
+		}
+		cfgs[i] = c
+	}
+	return cfgs
+}
+
+// formatSink copies every result to one lineSink per format, each writing
+// its own file with its own buffered writer, so one run produces e.g.
+// go-output.txt and go-output.json.
+type formatSink []*lineSink
+
+// openFormats opens the file of every format before the run starts, so an
+// unwritable destination is reported before any work is done. On error the
+// files opened so far are closed again.
+func openFormats(cfgs []outputConfig, logger *slog.Logger) (formatSink, error) {
+	s := make(formatSink, 0, len(cfgs))
+	for _, c := range cfgs {
+		f, err := openLineSink(c, logger)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = append(s, f)
+	}
+	return s, nil
+}
+
+// Write adds r to the file of every format.
+func (s formatSink) Write(r Result) error {
+	for _, f := range s {
+		if err := f.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes out every format's pending batch.
+func (s formatSink) Flush() error {
+	for _, f := range s {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close finishes every format's file, joining their errors.
+func (s formatSink) Close() error {
+	var errs []error
+	for _, f := range s {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// outputFiles lists the files in format order.
+func (s formatSink) outputFiles() []OutputFile {
+	var outputs []OutputFile
+	for _, f := range s {
+		outputs = append(outputs, f.outputFiles()...)
+	}
+	return outputs
+}
@@ -0,0 +1,48 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotRunning is reported by Health before Run has started the
+	// workers, and once they have all exited.
+	ErrNotRunning = errors.New("pool is not running")
+
+	// ErrShuttingDown is reported by Health once the pool was closed or its
+	// run was cancelled (a signal, -deadline or fail-fast): workers are
+	// finishing up and no new work will be taken.
+	ErrShuttingDown = errors.New("pool is shutting down")
+)
+
+// Health reports whether the pool is healthy: nil while Run is in progress
+// with live workers, the pool is open and the output works. Otherwise it
+// returns ErrNotRunning, ErrShuttingDown, or, once the output failed for
+// good and stopped the run, an error wrapping the output error (also after
+// Run returned). It is meant
+// for liveness and readiness probes and may be called from any goroutine.
+func (p *Pool[T]) Health() error {
+	runCtx := p.runCtx.Load()
+	if runCtx == nil {
+		return ErrNotRunning
+	}
+	// The output aborts the run with its error as the cause; any other
+	// cancellation is a regular shutdown. The cause outlives the run.
+	ctx := *runCtx
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) && !errors.Is(cause, context.DeadlineExceeded) {
+		return fmt.Errorf("output failed: %w", cause)
+	}
+	if p.live.Load() == 0 {
+		return ErrNotRunning
+	}
+	select {
+	case <-ctx.Done():
+		return ErrShuttingDown
+	case <-p.closing:
+		return ErrShuttingDown
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package workerpool
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// localFlushInterval is how long a worker with a local result buffer (see
+// WithLocalBuffer) may sit on a partial batch, e.g. while the queue is
+// empty, before handing it over anyway.
+const localFlushInterval = 50 * time.Millisecond
+
+// resultBuffer is a worker's local batch of finished results. With
+// WithLocalBuffer, workers send whole batches to the pool's batches channel
+// instead of every result to resultsChan, so with many fast workers they
+// contend for a channel far less often. Only its worker touches it.
+type resultBuffer struct {
+	out     chan<- []Result
+	size    int
+	results []Result
+}
+
+func newResultBuffer(out chan<- []Result, size int) *resultBuffer {
+	return &resultBuffer{out: out, size: size, results: make([]Result, 0, size)}
+}
+
+// add buffers r and flushes the batch once it is full. It reports false if
+// the batch could not be handed over because ctx was cancelled.
+func (b *resultBuffer) add(ctx context.Context, logger *slog.Logger, r Result) bool {
+	b.results = append(b.results, r)
+	if len(b.results) < b.size {
+		return true
+	}
+	return b.flush(ctx, logger)
+}
+
+// flush hands the buffered results over, if any. The receiver owns the
+// slice from then on, so the buffer starts a new one. If there is no room
+// and ctx is cancelled first, the results are logged as abandoned and
+// dropped, like a result the worker could not send, and flush reports
+// false.
+func (b *resultBuffer) flush(ctx context.Context, logger *slog.Logger) bool {
+	if len(b.results) == 0 {
+		return true
+	}
+	// Prefer handing over finished work even once ctx is cancelled; a
+	// select would pick either case at random.
+	select {
+	case b.out <- b.results:
+		b.results = make([]Result, 0, b.size)
+		return true
+	default:
+	}
+	select {
+	case b.out <- b.results:
+		b.results = make([]Result, 0, b.size)
+		return true
+	case <-ctx.Done():
+		for _, r := range b.results {
+			abandoned(logger, r.WorkerID, r.TaskID, ctx.Err())
+		}
+		b.results = b.results[:0]
+		return false
+	}
+}
+
+// unbatch passes the results of every batch on to resultsChan, in order,
+// until batches is closed, and then closes resultsChan. Being the only
+// sender on resultsChan, it never contends with the workers for it. Once
+// ctx is cancelled the writer stops waiting for results, so the rest are
+// dropped.
+func unbatch(ctx context.Context, batches <-chan []Result, resultsChan chan<- Result) {
+	defer close(resultsChan)
+	for batch := range batches {
+		for _, r := range batch {
+			select {
+			case resultsChan <- r:
+				continue
+			default:
+			}
+			select {
+			case resultsChan <- r:
+			case <-ctx.Done():
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// BenchmarkLocalBuffer runs b.N tasks through eight workers without the
+// simulated delay, with and without WithLocalBuffer, so ns/op is the cost
+// of one task in the pipeline.
+func BenchmarkLocalBuffer(b *testing.B) {
+	for _, n := range []int{0, 64} {
+		b.Run(fmt.Sprintf("local=%d", n), func(b *testing.B) {
+			p := NewPool[string](8, fastProcessor(),
+				WithOutputWriter(io.Discard),
+				WithQueueSize(1024),
+				WithLocalBuffer(n),
+				WithLogger(quietLogger()),
+			)
+			go func() {
+				defer p.Close()
+				for i := 1; i <= b.N; i++ {
+					p.Submit(StringTask{ID: i, Payload: "data"})
+				}
+			}()
+			b.ResetTimer()
+			if err := p.Run(context.Background()); err != nil {
+				b.Fatalf("Run() = %v", err)
+			}
+		})
+	}
+}
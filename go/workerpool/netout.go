@@ -0,0 +1,237 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tcpScheme marks an output path as a network address, e.g.
+// tcp://localhost:9000: TCPSink dials it and streams result lines over the
+// connection instead of writing a file.
+const tcpScheme = "tcp://"
+
+const (
+	// dialTimeout bounds each attempt to connect to a tcp:// output.
+	dialTimeout = 5 * time.Second
+
+	// reconnectBaseDelay is the wait before the first reconnect attempt
+	// after the output connection failed. Each further attempt doubles it,
+	// up to reconnectMaxDelay; after reconnectAttempts failed attempts the
+	// output is given up. It is also given up after reconnectAttempts
+	// failed sends in a row, even if every reconnect succeeded.
+	reconnectBaseDelay = 100 * time.Millisecond
+	reconnectMaxDelay  = 5 * time.Second
+	reconnectAttempts  = 10
+)
+
+// tcpAddr returns the host:port of a tcp:// output path, and whether path
+// is one.
+func tcpAddr(path string) (string, bool) {
+	return strings.CutPrefix(path, tcpScheme)
+}
+
+// dialOutput connects to the tcp:// output at addr, giving up when ctx is
+// cancelled. Run dials before any worker starts, so an unreachable collector
+// aborts the run up front, just like an unwritable output file.
+func dialOutput(ctx context.Context, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to output '%s%s': %w", tcpScheme, addr, err)
+	}
+	return conn, nil
+}
+
+// TCPSink is the Sink that streams result lines to a collector listening
+// at addr (host:port), the same as the output path "tcp://" + addr. Run
+// dials it before any worker starts, so an unreachable collector fails the
+// run up front, just like an unwritable output file. Results are formatted
+// and ordered as for a file, and buffering and batching still apply; gzip,
+// sharding, several formats and rotation do not.
+func TCPSink(addr string) Sink {
+	return &tcpSink{addr: addr}
+}
+
+// tcpSink is the Sink behind TCPSink: the sole owner of conn. Lines are sent
+// once cfg.batchSize of them (or, without batching, a write buffer's worth
+// of bytes) have accumulated, on Flush, and on Close. A CSV header starts
+// every connection. With cfg.checkpoint, the IDs of successful results are
+// marked done once their lines were sent.
+//
+// If a send fails, the connection is closed and dialed again with
+// exponential backoff, and the unsent lines are sent again on the new
+// connection. Lines the collector received just before the failure may
+// therefore arrive twice, while lines the kernel accepted after the
+// collector went away, before the failure showed, are lost: TCP does not
+// acknowledge delivery to the application. Once reconnectAttempts attempts
+// to reconnect, or reconnectAttempts sends over fresh connections, have
+// failed in a row, or the run's ctx is cancelled while reconnecting, the
+// output is given up: Write, Flush or Close returns the error and nothing
+// more is sent, so sinkWriter stops the run.
+type tcpSink struct {
+	addr string
+
+	// Set by open. ctx is the run's, and ends the reconnect backoff.
+	ctx    context.Context
+	cfg    outputConfig
+	logger *slog.Logger
+	conn   net.Conn
+	limit  int
+	header []byte
+
+	// pending holds the lines not sent yet (with cfg.sortBatches they wait
+	// in sorted until the flush), and unflushed the IDs of the successful
+	// results among them. fresh is set while the current connection has not
+	// got its CSV header; fatal once the output is given up. failedSends
+	// counts the sends that failed since the last one that went through,
+	// across reconnects.
+	pending     []byte
+	sorted      sortedBatch
+	lines       int
+	unflushed   []int
+	fresh       bool
+	fatal       error
+	failedSends int
+}
+
+func (s *tcpSink) open(ctx context.Context, cfg outputConfig, logger *slog.Logger, _ int) error {
+	conn, err := dialOutput(ctx, s.addr)
+	if err != nil {
+		return err
+	}
+	s.ctx, s.cfg, s.logger, s.conn = ctx, cfg, logger, conn
+	s.fresh = true
+	s.limit = cfg.writeBuffer
+	if s.limit < minWriteBuffer {
+		s.limit = 4096
+	}
+	if cfg.format == FormatCSV {
+		h, _ := formatCSV(csvHeader(cfg.verbose))
+		s.header = []byte(h)
+	}
+	return nil
+}
+
+// reconnect replaces the failed connection, backing off between attempts,
+// and returns nil once it succeeded. A cancelled ctx ends the backoff and
+// any dial in progress.
+func (s *tcpSink) reconnect(cause error) error {
+	s.conn.Close()
+	delay := reconnectBaseDelay
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		s.logger.Warn(fmt.Sprintf("Output connection to %s failed (%v); reconnecting in %v (attempt %d/%d)", s.addr, cause, delay, attempt, reconnectAttempts),
+			"event", "RECONNECTING", "attempt", attempt)
+		select {
+		case <-s.ctx.Done():
+			return fmt.Errorf("output connection to '%s%s' lost: %w; gave up reconnecting: %w", tcpScheme, s.addr, cause, s.ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+
+		conn, err := dialOutput(s.ctx, s.addr)
+		if err == nil {
+			s.conn = conn
+			s.logger.Info(fmt.Sprintf("Reconnected output to: %s", s.addr), "event", "RECONNECTED")
+			return nil
+		}
+		cause = err
+	}
+	return fmt.Errorf("output connection to '%s%s' lost: %w", tcpScheme, s.addr, cause)
+}
+
+// send sends the pending lines, reconnecting and resending them as often as
+// needed.
+func (s *tcpSink) send() {
+	if s.fatal != nil {
+		return
+	}
+	if s.cfg.sortBatches {
+		s.pending = s.sorted.appendTo(s.pending)
+	}
+	for {
+		out := s.pending
+		if s.fresh {
+			out = append(slices.Clip(s.header), s.pending...)
+		}
+		if len(out) == 0 {
+			return
+		}
+		_, err := s.conn.Write(out)
+		if err == nil {
+			s.failedSends = 0
+			break
+		}
+		// A collector that accepts connections but fails every send would
+		// otherwise be redialled forever.
+		if s.failedSends++; s.failedSends >= reconnectAttempts {
+			s.conn.Close()
+			s.fatal = fmt.Errorf("output connection to '%s%s' lost: %d sends in a row failed: %w", tcpScheme, s.addr, s.failedSends, err)
+			return
+		}
+		if s.fatal = s.reconnect(err); s.fatal != nil {
+			return
+		}
+		// A new connection is a new stream, so it gets its own header.
+		s.fresh = true
+	}
+	s.fresh = false
+	if s.cfg.checkpoint != nil {
+		s.cfg.checkpoint.markDone(s.unflushed)
+	}
+	s.pending, s.lines, s.unflushed = s.pending[:0], 0, s.unflushed[:0]
+}
+
+// Write formats r and queues its line, sending the queue once it is full.
+func (s *tcpSink) Write(r Result) error {
+	if s.fatal != nil {
+		return s.fatal
+	}
+	line, err := s.cfg.formatLine(r)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("failed to format result for Task-%d: %v", r.TaskID, err), "task", r.TaskID)
+		return nil
+	}
+	if s.cfg.sortBatches {
+		s.sorted.add(r.TaskID, line)
+	} else {
+		s.pending = append(s.pending, line...)
+	}
+	s.lines++
+	if s.cfg.checkpoint != nil && r.Err == nil {
+		s.unflushed = append(s.unflushed, r.TaskID)
+	}
+	if (s.cfg.batchSize > 0 && s.lines >= s.cfg.batchSize) || (s.cfg.batchSize <= 0 && len(s.pending) >= s.limit) {
+		s.send()
+	}
+	return s.fatal
+}
+
+// Flush sends the pending lines.
+func (s *tcpSink) Flush() error {
+	s.send()
+	return s.fatal
+}
+
+// Close sends the remaining lines and closes the connection. It returns the
+// error that broke the output if that happened only now.
+func (s *tcpSink) Close() error {
+	if s.fatal != nil {
+		return nil
+	}
+	if s.send(); s.fatal != nil {
+		return s.fatal
+	}
+	if err := s.conn.Close(); err != nil {
+		return fmt.Errorf("failed to close output connection: %w", err)
+	}
+	return nil
+}
+
+func (s *tcpSink) outputFiles() []OutputFile {
+	return nil
+}
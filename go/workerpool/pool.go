@@ -0,0 +1,1259 @@
+// Package workerpool implements the concurrent data processing pipeline: a
+// pool of worker goroutines that pull tasks from a shared queue, process them
+// with a pluggable Processor, and hand results to a single writer goroutine
+// that owns the output, a Sink such as the output file. Pools, Tasks and
+// Processors are generic over the payload type; StringTask covers the
+// common text case.
+//
+// Typical use:
+//
+//	p := workerpool.NewPool[string](4, workerpool.NewSimulatedProcessor())
+//	go func() {
+//		for i := 1; i <= 20; i++ {
+//			p.Submit(workerpool.StringTask{ID: i, Payload: fmt.Sprintf("data-%d", i)})
+//		}
+//		p.Close()
+//	}()
+//	if err := p.Run(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// Task represents a single unit of work in the system, carrying a payload
+// of type T for the Processor.
+// Keeping it immutable-by-convention (no in-place mutation after creation)
+// reduces concurrency complexity and makes task handling predictable.
+type Task[T any] struct {
+	ID      int
+	Payload T
+
+	// Priority only matters with WithPriorityQueue: higher values are handed
+	// to workers first. The zero value is the normal priority.
+	Priority int
+
+	// Weight is the relative cost of the task for WithFairScheduling, e.g.
+	// 10 for a task that takes ten times as long as a typical one. Unset
+	// (or not positive) counts as 1.
+	Weight int
+
+	// DependsOn lists the IDs of tasks that must complete successfully
+	// before this one is started. It only matters with WithDependencies.
+	DependsOn []int
+
+	// Deadline, if set, is when the task stops being worth processing. A
+	// worker that picks the task up later drops it without calling the
+	// Processor and emits a Result with Err set to ErrExpired.
+	Deadline time.Time
+
+	// Meta holds key-value tags such as a trace ID or tenant that travel
+	// with the task into its Result untouched; Processors may read it.
+	Meta map[string]string
+}
+
+// ErrPoolClosed is returned by SubmitCtx when the pool is closed, or is
+// closed while the submission is waiting for room in the queue.
+var ErrPoolClosed = errors.New("pool is closed")
+
+// ErrQueueFull is returned by TrySubmit when the queue, or the
+// WithMaxInflightBytes budget, has no room for the task.
+var ErrQueueFull = errors.New("task queue is full")
+
+// StringTask is the Task used by the built-in text sources and
+// SimulatedProcessor.
+type StringTask = Task[string]
+
+// job is a queued Task tagged with its submission sequence number (0, 1, 2,
+// ...). The writer uses it to restore submission order in ordered mode; task
+// IDs themselves may have gaps (e.g. skipped blank input lines).
+type job[T any] struct {
+	task Task[T]
+	seq  int
+
+	// skip, if set, makes the worker fail the task with this error without
+	// processing it (a dependency failed; see dagDispatch).
+	skip error
+
+	// size is the payload bytes the task holds against the
+	// WithMaxInflightBytes budget; the worker releases them when done.
+	size int64
+}
+
+// Pool owns the channel plumbing between the producer, the workers and the
+// writer. Create one with NewPool, feed it with Submit/Close and drive it with
+// Run. A Pool runs once; it cannot be restarted after Run returns.
+//
+// T is the task payload type; results are always rendered as strings by the
+// Processor, so output formats do not depend on T.
+type Pool[T any] struct {
+	settings
+	numWorkers int
+	proc       Processor[T]
+
+	// tasks acts as a concurrency-safe queue shared by all workers.
+	tasks chan job[T]
+
+	// With DispatchRoundRobin, the roundRobin dispatcher moves jobs from
+	// tasks to lanes, indexed by worker ID (entry 0 is unused), and each
+	// worker receives from its own lane instead of tasks.
+	lanes []chan job[T]
+
+	// With WithLocalBuffer, workers send batches of results to batches, and
+	// unbatch forwards them to the writer's results channel.
+	batches chan []Result
+
+	// With WithPriorityQueue, Submit feeds incoming instead and the dispatch
+	// goroutine moves jobs to the (then unbuffered) tasks channel in priority
+	// order. queued counts jobs submitted but not yet handed to a worker.
+	incoming chan job[T]
+	queued   atomic.Int64
+
+	// submitted numbers tasks in submission order for ordered output.
+	// trySubmitMu serializes TrySubmit so a rejected task never uses up a
+	// sequence number.
+	submitted   atomic.Int64
+	trySubmitMu sync.Mutex
+
+	// closing is closed first thing in Close, waking any SubmitCtx waiting
+	// for room. SubmitCtx holds sendMu for reading while it may send, and
+	// Close takes it for writing before closing the queue, so a send can
+	// never hit a closed channel.
+	closing   chan struct{}
+	closeOnce sync.Once
+	sendMu    sync.RWMutex
+
+	// completions carries finished task IDs from the workers to the DAG
+	// dispatcher (WithDependencies), which closes dagDone when it returns.
+	completions chan completion
+	dagDone     chan struct{}
+
+	// resumed is nil while the pool runs normally. Pause sets it to a fresh
+	// channel that Resume closes; workers wait on it before taking a task.
+	pauseMu sync.Mutex
+	resumed chan struct{}
+
+	// finished is closed when Run returns. stopRun cancels the running Run
+	// for a Shutdown whose drain timed out; stopRequested makes a Run that
+	// has not started yet stop at once. shutdownErr is what Shutdown
+	// returned, for later calls.
+	finished      chan struct{}
+	stopMu        sync.Mutex
+	stopRun       context.CancelFunc
+	stopRequested bool
+	shutdownErr   error
+
+	// runCtx is the context of the current Run, with fail-fast and output
+	// aborts wired in, stored once the workers start; Health reads it.
+	runCtx atomic.Pointer[context.Context]
+
+	// live counts running workers. allExited is closed when it drops to 0,
+	// which only happens once the queue is closed and drained or the run is
+	// cancelled: an idle worker never retires while it is the last one.
+	live      atomic.Int32
+	allExited chan struct{}
+
+	// breaker is shared by all workers; nil without WithCircuitBreaker.
+	breaker *breaker
+
+	// sem holds one token per processing attempt in progress; nil without
+	// a WithMaxConcurrency limit below the worker count.
+	sem chan struct{}
+
+	// budget holds the payload bytes of the tasks submitted and not yet
+	// processed; nil without WithMaxInflightBytes.
+	budget *byteBudget
+
+	// ready has a slot per initial worker; each one sends on it once it has
+	// started, and WaitReady collects the signals.
+	ready chan struct{}
+
+	// workerStats is indexed by worker ID (entry 0 is unused). Each entry is
+	// only written by its own worker, and the slice only grows in Run and
+	// the supervisor, so no lock is needed; read it after every worker exited.
+	workerStats []*WorkerStats
+
+	// counts backs Stats and is updated as tasks are queued and finish.
+	counts taskCounts
+
+	// errs carries task failures from workers (and tasks refused by the
+	// Validator) to the error collector. Run closes it only after all
+	// workers finish, so no worker can send on a closed channel.
+	errs chan error
+
+	// failed is the number of permanently failed tasks, set when Run returns.
+	failed int
+
+	// outputFiles are the output files written by Run, set when it returns.
+	outputFiles []OutputFile
+}
+
+// settings holds the Pool configuration that does not depend on the payload
+// type, so Options need no type parameter.
+type settings struct {
+	maxWorkers   int
+	taskTimeout  time.Duration
+	escalation   float64
+	retries      int
+	out          outputConfig
+	limiter      *rate.Limiter
+	observers    []Observer
+	logger       *slog.Logger
+	tracer       trace.Tracer
+	deadLetter   string
+	priority     bool
+	fair         bool
+	dependencies bool
+	queueSize    int
+	failFast     bool
+	idleTimeout  time.Duration
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	// maxConcurrency > 0 caps the processing attempts running at once.
+	maxConcurrency int
+
+	dispatch Dispatch
+
+	// maxInflightBytes > 0 caps the payload bytes submitted and not yet
+	// processed.
+	maxInflightBytes int64
+
+	// validator wraps the Validator[T] of WithValidator; nil accepts every
+	// task.
+	validator func(any) error
+
+	// resultsBuffer < 0 means "same as the task queue".
+	resultsBuffer int
+
+	// localBuffer > 1 makes workers batch results; see WithLocalBuffer.
+	localBuffer int
+}
+
+// Observer is notified by workers each time a task finishes (successfully
+// or not), with the time spent on it including retries and backoff. It is
+// the hook for metrics and progress reporting. TaskFinished is called
+// concurrently from every worker, so implementations must be safe for
+// concurrent use and should return quickly.
+type Observer interface {
+	TaskFinished(r Result, elapsed time.Duration)
+}
+
+// WorkerStats is the load handled by one worker during a run: the number of
+// tasks it finished (in any outcome) and the time spent on them, including
+// retries and backoff.
+type WorkerStats struct {
+	ID    int
+	Tasks int
+	Busy  time.Duration
+}
+
+// Stats is a point-in-time snapshot of a Pool's task counters; see
+// Pool.Stats.
+type Stats struct {
+	// Submitted counts tasks accepted into the queue.
+	Submitted int64
+	// Completed and Failed count tasks a worker finished, by outcome. Failed
+	// includes expired tasks and tasks skipped for a failed dependency.
+	Completed int64
+	Failed    int64
+	// InFlight counts tasks taken by a worker and not finished yet.
+	InFlight int64
+	// QueueDepth counts tasks waiting for a worker.
+	QueueDepth int
+	// Rejected counts tasks the WithValidator Validator refused; they are
+	// not included in Submitted.
+	Rejected int64
+	// InflightBytes is the payload size of the tasks submitted and not
+	// finished yet; it is only tracked with WithMaxInflightBytes.
+	InflightBytes int64
+}
+
+// taskCounts are the atomics behind Stats.
+type taskCounts struct {
+	submitted atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	inFlight  atomic.Int64
+	rejected  atomic.Int64
+}
+
+// Option configures optional Pool settings in NewPool.
+type Option func(*settings)
+
+// WithOutputPath sets the file results are written to (see FileSink); "-"
+// writes to standard output (StdoutSink), and "tcp://host:port" streams the
+// result lines to a collector listening there (TCPSink). The default is
+// "target/go-output.txt". It is ignored with WithSink or WithOutputWriter.
+func WithOutputPath(path string) Option {
+	return func(s *settings) { s.out.path = path }
+}
+
+// WithOutputWriter sends the result lines to w instead of a file, e.g. a
+// bytes.Buffer in a test or a caller's network stream; the output path is
+// then ignored. The lines are formatted, buffered, batched and optionally
+// gzip-compressed as for a file, but sharding, several formats and rotation
+// need files and are disabled. Run flushes everything into w before it
+// returns and closes w if it is an io.Closer; w must not be used while Run
+// is in progress. If w is an *os.File, it is listed in OutputFiles with its
+// name and checksum.
+func WithOutputWriter(w io.Writer) Option {
+	return WithSink(WriterSink(w))
+}
+
+// WithTaskTimeout bounds each processing attempt; 0 or negative disables it.
+func WithTaskTimeout(d time.Duration) Option {
+	return func(s *settings) { s.taskTimeout = d }
+}
+
+// WithTimeoutEscalation multiplies the task timeout by factor for each
+// retry that follows a timed-out attempt, so a merely slow task gets a
+// longer second chance while a stuck one still fails. With factor 2 and
+// WithTaskTimeout(time.Second), the attempts after two timeouts get 2s and
+// then 4s. An attempt that failed otherwise does not raise the timeout.
+// Every attempt still ends with the run's context, so escalation never
+// outlasts a run deadline. A factor of 1 or less, the default, disables it.
+func WithTimeoutEscalation(factor float64) Option {
+	return func(s *settings) { s.escalation = factor }
+}
+
+// WithRetries sets how many times a failed task is retried with exponential
+// backoff before it is reported as permanently failed. The default is 0.
+func WithRetries(n int) Option {
+	return func(s *settings) { s.retries = n }
+}
+
+// WithFormat sets the output format. The default is FormatText.
+func WithFormat(f Format) Option {
+	return func(s *settings) { s.out.format = f }
+}
+
+// WithFormats writes every result in each of formats at once, to one file
+// per format named after the output path with the format's extension, e.g.
+// go-output.txt and go-output.json. Each format has its own buffered
+// writer, all flushed and closed when Run ends. With a single format it is
+// the same as WithFormat. It does not combine with sharding, which only
+// uses the first format, or with standard output.
+func WithFormats(formats ...Format) Option {
+	return func(s *settings) {
+		if len(formats) > 0 {
+			s.out.format = formats[0]
+		}
+		s.out.formats = nil
+		if len(formats) > 1 {
+			s.out.formats = formats
+		}
+	}
+}
+
+// WithVerbose adds each task's attempt count and duration (Result.Attempts
+// and Result.Duration) to every output line: "attempts=N duration=D" in text,
+// "attempts" and "duration_ms" fields in JSON, and columns of the same names
+// in CSV. Off by default, so the default layouts stay unchanged.
+func WithVerbose(enabled bool) Option {
+	return func(s *settings) { s.out.verbose = enabled }
+}
+
+// WithOrdered makes the writer emit results in submission order (ascending
+// Task.ID for the built-in sources) instead of completion order. Results that
+// finish early are held in memory until every earlier task has been written,
+// so a slow task near the front of the queue can make many results pile up.
+func WithOrdered(ordered bool) Option {
+	return func(s *settings) { s.out.ordered = ordered }
+}
+
+// WithGzip compresses the output file with gzip. The output path is used as
+// given, so callers normally end it in ".gz".
+func WithGzip(enabled bool) Option {
+	return func(s *settings) { s.out.gzip = enabled }
+}
+
+// WithAppend opens the output file in append mode, keeping results from
+// earlier runs instead of truncating them. Flushing and closing are
+// unchanged. It does not combine with WithGzip unless the file is new:
+// appending a gzip stream to an existing plain file corrupts both.
+func WithAppend(enabled bool) Option {
+	return func(s *settings) { s.out.append = enabled }
+}
+
+// WithObserver registers o to be notified as tasks finish. It may be given
+// more than once; observers are called in registration order.
+func WithObserver(o Observer) Option {
+	return func(s *settings) { s.observers = append(s.observers, o) }
+}
+
+// WithTracer wraps the processing of every task, including its retries, in
+// a span started from t, with "task.id", "task.attempts" and "worker.id"
+// attributes and an error status if the task fails. Spans are children of
+// whatever span the context passed to Run carries. Without a tracer (the
+// default) no spans are created at all.
+func WithTracer(t trace.Tracer) Option {
+	return func(s *settings) { s.tracer = t }
+}
+
+// WithLogger sets the logger used by the workers, the writer and the error
+// collector. Log records carry "worker", "task" and "event" attributes (event
+// is STARTED, PICKED, COMPLETED, FINISHED, ...) so structured handlers can
+// index them. The default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(s *settings) { s.logger = l }
+}
+
+// WithMaxWorkers enables autoscaling: the pool starts with the numWorkers
+// given to NewPool and adds workers, up to max in total, while the task queue
+// stays near full. Added workers exit again after a short idle period. A max
+// not greater than numWorkers disables autoscaling.
+func WithMaxWorkers(max int) Option {
+	return func(s *settings) { s.maxWorkers = max }
+}
+
+// WithIdleTimeout lets every worker, not only those added by the autoscaler,
+// exit after d without a task, shrinking the pool during quiet periods. The
+// last running worker never exits this way, and a supervisor starts
+// replacements, up to the numWorkers given to NewPool, as soon as tasks are
+// queued again. 0 or negative disables it.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *settings) { s.idleTimeout = d }
+}
+
+// WithRateLimit caps throughput across the whole pool at perSecond tasks per
+// second; workers wait for a token before processing each task. 0 or
+// negative means unlimited.
+func WithRateLimit(perSecond float64) Option {
+	return func(s *settings) {
+		s.limiter = nil
+		if perSecond > 0 {
+			s.limiter = rate.NewLimiter(rate.Limit(perSecond), 1)
+		}
+	}
+}
+
+// WithShardBy splits the output across several files; see ShardBy. Each
+// shard file has its own buffered writer. WithOrdered is ignored when
+// sharding.
+func WithShardBy(m ShardBy) Option {
+	return func(s *settings) { s.out.shardBy = m }
+}
+
+// WithShards hash-partitions the output across n files by task ID
+// (taskID % n), named after the output path with a "-shard-K" suffix. Every
+// shard file is created, even if no result lands in it. n <= 1 keeps the
+// single output file. It takes precedence over WithShardBy.
+func WithShards(n int) Option {
+	return func(s *settings) { s.out.shards = n }
+}
+
+// WithWriteBuffer sets the size in bytes of the buffered writer in front of
+// the output file. Larger buffers mean fewer write syscalls for long result
+// lines. Sizes below 512 bytes fall back to the bufio default of 4096.
+func WithWriteBuffer(size int) Option {
+	return func(s *settings) { s.out.writeBuffer = size }
+}
+
+// WithBatching makes the writer accumulate up to size formatted lines and
+// write them as one block followed by a flush, or flush whatever has
+// accumulated every interval, whichever comes first. size <= 0 disables
+// batching: lines go straight into the buffered writer, which is flushed
+// when the run ends.
+func WithBatching(size int, interval time.Duration) Option {
+	return func(s *settings) {
+		s.out.batchSize = size
+		s.out.batchInterval = interval
+	}
+}
+
+// WithSortedBatches makes the writer sort each batch (see WithBatching) by
+// task ID before writing it, so lines are in order within a batch though
+// not across batches. Unlike WithOrdered, it never holds more than one
+// batch in memory. It has no effect without batching or with WithOrdered.
+func WithSortedBatches(enabled bool) Option {
+	return func(s *settings) { s.out.sortBatches = enabled }
+}
+
+// WithRotateSize rotates the output once a file holds at least bytes bytes
+// of (uncompressed) results: the writer closes it and continues in the next
+// numbered file, e.g. go-output.1.txt, go-output.2.txt. Files only ever
+// switch between lines. bytes <= 0 disables rotation, as does writing to
+// standard output.
+func WithRotateSize(bytes int64) Option {
+	return func(s *settings) { s.out.rotateSize = bytes }
+}
+
+// WithRotateInterval rotates the output on a wall-clock schedule: the
+// first line after each interval of d ends (on the hour for time.Hour) goes
+// to a new file named after the UTC start of its interval, e.g.
+// go-output.20261015T140000Z.txt. Intervals without results create no file.
+// Combined with WithRotateSize, the files of an interval are numbered as
+// well. d <= 0 disables it, as does writing to standard output.
+func WithRotateInterval(d time.Duration) Option {
+	return func(s *settings) { s.out.rotateInterval = d }
+}
+
+// WithPriorityQueue hands queued tasks to idle workers in descending
+// Task.Priority order instead of strictly first in, first out; tasks with
+// equal priority keep their submission order. It costs one extra dispatcher
+// goroutine, so leave it off when priorities are not used.
+func WithPriorityQueue(enabled bool) Option {
+	return func(s *settings) { s.priority = enabled }
+}
+
+// WithFairScheduling hands queued tasks to workers by weighted fair
+// queuing on Task.Weight instead of first in, first out: tasks of each
+// weight get an equal share of the total weight handed out over time (see
+// fairQueue), so a burst of heavy tasks cannot occupy every worker ahead of
+// many light ones, while the heavy ones still make steady progress. With
+// WithPriorityQueue as well, priority orders the tasks within a weight. It
+// costs one extra dispatcher goroutine and is ignored with
+// WithDependencies.
+func WithFairScheduling(enabled bool) Option {
+	return func(s *settings) { s.fair = enabled }
+}
+
+// WithDependencies makes the pool a simple DAG executor: a task is only
+// handed to a worker once every task in its DependsOn has completed
+// successfully. Submitted tasks are held until Close, when the dependency
+// graph is checked; duplicate IDs, unknown dependencies and cycles make Run
+// return an error without processing anything. Dependents of a task that
+// fails are not processed either: they fail with ErrDependencyFailed. Ready
+// tasks are started in Task.Priority order.
+func WithDependencies(enabled bool) Option {
+	return func(s *settings) { s.dependencies = enabled }
+}
+
+// WithCheckpoint makes the writer record the IDs of successfully processed
+// tasks in a JSON checkpoint file at path, once their output has been
+// flushed. It is saved every batch interval (see WithBatching) and at the
+// end of the run. done seeds the set, normally with the IDs returned by
+// LoadCheckpoint, so a resumed run keeps what earlier runs completed; it may
+// be nil. Skipping completed tasks on resume is up to the producer.
+func WithCheckpoint(path string, done map[int]struct{}) Option {
+	return func(s *settings) { s.out.checkpoint = newCheckpoint(path, done) }
+}
+
+// WithDeadLetter writes every task that still fails after all retries to a
+// JSON Lines file at path, one {"id","payload","attempts","error"} record per
+// task, so it can be re-submitted later. The file is created even if nothing
+// fails.
+func WithDeadLetter(path string) Option {
+	return func(s *settings) { s.deadLetter = path }
+}
+
+// WithResultsBuffer sets the buffer size of the channel between the workers
+// and the writer. When it is full because the writer is slow, workers block
+// on sending, which throttles them to the writer's pace. The default matches
+// the task queue size.
+func WithResultsBuffer(n int) Option {
+	return func(s *settings) { s.resultsBuffer = n }
+}
+
+// WithLocalBuffer makes each worker collect up to n finished results
+// locally and send them on as one batch, instead of sending every result
+// to the writer's channel on its own. With many workers finishing tasks at
+// a high rate this cuts channel contention, at the cost of a little
+// latency: a partial batch is sent at the latest after localFlushInterval,
+// and whatever is left when the worker exits. n <= 1 disables it.
+func WithLocalBuffer(n int) Option {
+	return func(s *settings) { s.localBuffer = n }
+}
+
+// WithFailFast stops the run at the first task that fails permanently (after
+// its retries): Run cancels its context as if ctx had been cancelled, so
+// workers abandon their tasks and the writer flushes what has completed.
+func WithFailFast(enabled bool) Option {
+	return func(s *settings) { s.failFast = enabled }
+}
+
+// WithCircuitBreaker protects a failing downstream: after threshold
+// consecutive failed processing attempts (across all workers, retries
+// included), tasks fail fast with ErrBreakerOpen, without retries, for
+// cooldown. Like any permanent failure they go to the dead-letter file. Then
+// a single task is let through as a probe; if it succeeds processing
+// resumes, otherwise the breaker stays open for another cooldown.
+// threshold <= 0 disables it.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(s *settings) {
+		s.breakerThreshold = threshold
+		s.breakerCooldown = cooldown
+	}
+}
+
+// WithMaxConcurrency caps how many tasks are processed at the same time
+// across all workers, e.g. to stay within a downstream service's connection
+// limit while keeping more workers. Each worker takes one of n tokens before
+// a processing attempt and returns it afterwards, so retry backoff does not
+// hold a token. n <= 0, or n at or above the worker count (WithMaxWorkers
+// included), means no limit, which is the default.
+func WithMaxConcurrency(n int) Option {
+	return func(s *settings) { s.maxConcurrency = n }
+}
+
+// WithMaxInflightBytes bounds the memory held by queued work: Submit and
+// SubmitCtx wait while the payloads of the tasks submitted and not yet
+// processed, plus the new one, would exceed n bytes, and TrySubmit refuses
+// the task. A worker releases a task's bytes once it finished processing
+// it. Only string and []byte payloads are measured. A single task larger
+// than n is let through when nothing else is in flight. n <= 0 means no
+// limit, which is the default.
+func WithMaxInflightBytes(n int64) Option {
+	return func(s *settings) { s.maxInflightBytes = n }
+}
+
+// WithDispatch selects how queued tasks reach the workers; see Dispatch.
+// The default is DispatchShared. DispatchRoundRobin needs a fixed set of
+// workers, so it is ignored with WithMaxWorkers or WithIdleTimeout.
+func WithDispatch(d Dispatch) Option {
+	return func(s *settings) { s.dispatch = d }
+}
+
+// WithQueueSize sets the buffer size of the task queue. Buffering lets a
+// producer run ahead of the workers; the default is one slot per worker.
+func WithQueueSize(n int) Option {
+	return func(s *settings) { s.queueSize = n }
+}
+
+// NewPool returns a Pool that processes tasks with proc on numWorkers
+// goroutines. proc is shared by all workers and must be safe for concurrent
+// use. T usually has to be given explicitly, e.g.
+// NewPool[string](4, NewSimulatedProcessor()).
+func NewPool[T any](numWorkers int, proc Processor[T], opts ...Option) *Pool[T] {
+	p := &Pool[T]{
+		numWorkers: numWorkers,
+		proc:       proc,
+		closing:    make(chan struct{}),
+		finished:   make(chan struct{}),
+		ready:      make(chan struct{}, numWorkers),
+		settings: settings{
+			logger:        slog.Default(),
+			queueSize:     numWorkers,
+			resultsBuffer: -1,
+			dispatch:      DispatchShared,
+			out: outputConfig{
+				path:    "target/go-output.txt",
+				format:  FormatText,
+				shardBy: ShardNone,
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(&p.settings)
+	}
+	if p.out.sink == nil {
+		p.out.sink = pathSink(p.out.path)
+	}
+	switch s := p.out.sink.(type) {
+	case *fileSink:
+		p.out.path = s.path
+		if s.dest != nil {
+			// The caller's writer is the one and only stream.
+			p.out.append = false
+			p.out.shardBy, p.out.shards, p.out.formats = ShardNone, 0, nil
+			p.out.rotateSize, p.out.rotateInterval = 0, 0
+		}
+	case *tcpSink:
+		// A network output is a single stream of plain lines.
+		p.out.path = tcpScheme + s.addr
+		p.out.shardBy, p.out.shards, p.out.formats = ShardNone, 0, nil
+		p.out.gzip, p.out.rotateSize, p.out.rotateInterval = false, 0, 0
+	default:
+		// Any other sink gets results, not lines or files.
+		p.out.path, p.out.append = "", false
+		p.out.format, p.out.formats, p.out.template = FormatText, nil, nil
+		p.out.gzip, p.out.batchSize, p.out.sortBatches = false, 0, false
+		p.out.shardBy, p.out.shards = ShardNone, 0
+		p.out.rotateSize, p.out.rotateInterval = 0, 0
+	}
+	if p.out.shardBy != ShardNone || p.out.shards > 1 {
+		// Sequence numbers are global, so a per-shard reorder buffer would
+		// hold every result until the end of the run.
+		p.out.ordered = false
+	}
+	if p.out.batchSize <= 0 || p.out.ordered {
+		// There is no batch to sort, or the output is in order already.
+		p.out.sortBatches = false
+	}
+	if p.out.path == "-" {
+		// Several writers cannot share standard output.
+		p.out.formats = nil
+	}
+	if p.out.template != nil {
+		// The template is the only layout; in particular no CSV header.
+		p.out.format, p.out.formats = FormatText, nil
+	}
+	if p.maxWorkers > p.numWorkers || p.idleTimeout > 0 {
+		// Workers come and go, so there is no fixed rotation.
+		p.dispatch = DispatchShared
+	}
+	p.errs = make(chan error, max(p.numWorkers, p.maxWorkers))
+	if p.maxConcurrency > 0 && p.maxConcurrency < max(p.numWorkers, p.maxWorkers) {
+		p.sem = make(chan struct{}, p.maxConcurrency)
+	}
+	if p.maxInflightBytes > 0 {
+		p.budget = newByteBudget(p.maxInflightBytes)
+	}
+	if p.breakerThreshold > 0 {
+		p.breaker = newBreaker(p.breakerThreshold, p.breakerCooldown, p.logger)
+	}
+	p.tasks = make(chan job[T], p.queueSize)
+	if p.priority || p.dependencies || p.fair {
+		// The configured buffer becomes the submission queue; workers receive
+		// from an unbuffered channel so nothing is committed to a worker
+		// before it is ready.
+		p.incoming = p.tasks
+		p.tasks = make(chan job[T])
+	}
+	return p
+}
+
+// Submit queues t for processing. It blocks while the queue is full, or
+// while the WithMaxInflightBytes budget is used up, so it is normally
+// called from a producer goroutine running alongside Run. Submit
+// must not be called after Close. A task refused by the WithValidator
+// Validator is reported as failed instead of queued.
+func (p *Pool[T]) Submit(t Task[T]) {
+	if p.validate(t) != nil {
+		return
+	}
+	var size int64
+	if p.budget != nil {
+		size = payloadSize(t.Payload)
+		p.budget.acquire(context.Background(), nil, size)
+	}
+	seq := int(p.submitted.Add(1) - 1)
+	defer p.counts.submitted.Add(1)
+	if p.incoming != nil {
+		p.queued.Add(1)
+		p.incoming <- job[T]{task: t, seq: seq, size: size}
+		return
+	}
+	p.tasks <- job[T]{task: t, seq: seq, size: size}
+}
+
+// TrySubmit queues t if there is room, without blocking, and returns
+// ErrQueueFull if there is not. It is meant for callers such as request
+// handlers that must not wait on a full queue. TrySubmit is safe for
+// concurrent use, but should not be mixed with Submit on the same Pool when
+// ordered output is enabled, and must not be called after Close. A task
+// refused by the WithValidator Validator is reported as failed, and the
+// error, wrapping ErrInvalidTask, is returned; retrying it cannot help.
+func (p *Pool[T]) TrySubmit(t Task[T]) error {
+	if err := p.validate(t); err != nil {
+		return err
+	}
+	p.trySubmitMu.Lock()
+	defer p.trySubmitMu.Unlock()
+
+	j := job[T]{task: t, seq: int(p.submitted.Load())}
+	if p.budget != nil {
+		j.size = payloadSize(t.Payload)
+		if ok, _ := p.budget.tryAcquire(j.size); !ok {
+			return ErrQueueFull
+		}
+	}
+	queue := p.tasks
+	if p.incoming != nil {
+		queue = p.incoming
+		p.queued.Add(1)
+	}
+	select {
+	case queue <- j:
+		p.submitted.Add(1)
+		p.counts.submitted.Add(1)
+		return nil
+	default:
+		if p.incoming != nil {
+			p.queued.Add(-1)
+		}
+		p.budget.release(j.size)
+		return ErrQueueFull
+	}
+}
+
+// SubmitCtx queues t like Submit, waiting while the queue is full, but gives
+// up when ctx is done or the pool is closed: it returns ctx.Err() or
+// ErrPoolClosed instead of blocking forever or panicking on a closed queue.
+// It is safe for concurrent use and may race with Close, which makes it the
+// right choice for request handlers and other library callers. A task
+// refused by the WithValidator Validator is reported as failed, and the
+// error, wrapping ErrInvalidTask, is returned.
+//
+// Like TrySubmit it should not be combined with ordered output: a
+// submission that gives up leaves a gap in the submission order, which holds
+// back every later result until Run ends.
+func (p *Pool[T]) SubmitCtx(ctx context.Context, t Task[T]) error {
+	p.sendMu.RLock()
+	defer p.sendMu.RUnlock()
+
+	// Checked first so a closed pool wins over a queue that has room.
+	select {
+	case <-p.closing:
+		return ErrPoolClosed
+	default:
+	}
+	if err := p.validate(t); err != nil {
+		return err
+	}
+	var size int64
+	if p.budget != nil {
+		size = payloadSize(t.Payload)
+		if err := p.budget.acquire(ctx, p.closing, size); err != nil {
+			return err
+		}
+	}
+
+	j := job[T]{task: t, seq: int(p.submitted.Add(1) - 1), size: size}
+	queue := p.tasks
+	if p.incoming != nil {
+		queue = p.incoming
+		p.queued.Add(1)
+	}
+	select {
+	case queue <- j:
+		p.counts.submitted.Add(1)
+		return nil
+	case <-ctx.Done():
+		if p.incoming != nil {
+			p.queued.Add(-1)
+		}
+		p.budget.release(size)
+		return ctx.Err()
+	case <-p.closing:
+		if p.incoming != nil {
+			p.queued.Add(-1)
+		}
+		p.budget.release(size)
+		return ErrPoolClosed
+	}
+}
+
+// Close signals that no more tasks will be submitted. Workers finish
+// naturally once the queue is drained, which lets Run return. Pending
+// SubmitCtx calls return ErrPoolClosed. Calling Close more than once is a
+// no-op.
+func (p *Pool[T]) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+
+		p.sendMu.Lock()
+		defer p.sendMu.Unlock()
+		if p.incoming != nil {
+			close(p.incoming)
+			return
+		}
+		close(p.tasks)
+	})
+}
+
+// ErrDrainTimeout is returned by Shutdown when the queued and in-flight
+// tasks did not finish before its context expired.
+var ErrDrainTimeout = errors.New("pool did not drain in time")
+
+// Shutdown stops the pool gracefully, the programmatic counterpart of the
+// signal handling of the command: it closes the pool so no new tasks are
+// accepted, waits until the queued and in-flight tasks have finished and
+// the writer has flushed and closed the output, and returns once Run has
+// returned. Run's own error still goes to Run's caller.
+//
+// If ctx expires first, Shutdown cancels the run like a signal would:
+// workers abandon their tasks, the results already completed are written,
+// and Shutdown returns an error wrapping ErrDrainTimeout and ctx.Err() once
+// Run has returned. A pool whose Run has not started yet is stopped as soon
+// as it does.
+//
+// Shutdown may be called from any goroutine and more than once; later calls
+// wait for the same run and return the same result.
+func (p *Pool[T]) Shutdown(ctx context.Context) error {
+	p.Close()
+	select {
+	case <-p.finished:
+	case <-ctx.Done():
+		p.stopMu.Lock()
+		stop := p.stopRun
+		p.stopRequested = true
+		if p.shutdownErr == nil {
+			p.shutdownErr = fmt.Errorf("%w: %w", ErrDrainTimeout, ctx.Err())
+		}
+		p.stopMu.Unlock()
+		if stop == nil {
+			// Run has not started; it will stop at once when it does.
+			return p.shutdownResult()
+		}
+		stop()
+		<-p.finished
+	}
+	return p.shutdownResult()
+}
+
+func (p *Pool[T]) shutdownResult() error {
+	p.stopMu.Lock()
+	defer p.stopMu.Unlock()
+	return p.shutdownErr
+}
+
+// Pause stops workers from taking new tasks: each one finishes the task it
+// is working on and then waits until Resume. Queued tasks stay queued, and
+// submitting still works until the queue is full. Cancelling the Run context
+// still stops a paused pool. Pause reports whether it changed anything, i.e.
+// false if the pool was already paused.
+func (p *Pool[T]) Pause() bool {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	if p.resumed != nil {
+		return false
+	}
+	p.resumed = make(chan struct{})
+	return true
+}
+
+// Resume lets paused workers take tasks again. It reports false if the pool
+// was not paused.
+func (p *Pool[T]) Resume() bool {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	if p.resumed == nil {
+		return false
+	}
+	close(p.resumed)
+	p.resumed = nil
+	return true
+}
+
+// Paused reports whether the pool is currently paused.
+func (p *Pool[T]) Paused() bool {
+	return p.pauseWait() != nil
+}
+
+// pauseWait returns a channel that is closed on Resume while the pool is
+// paused, and nil otherwise.
+func (p *Pool[T]) pauseWait() <-chan struct{} {
+	p.pauseMu.Lock()
+	defer p.pauseMu.Unlock()
+	return p.resumed
+}
+
+// Failed returns the number of tasks that failed permanently, after all
+// retries, in the last Run. It is only meaningful once Run has returned.
+func (p *Pool[T]) Failed() int {
+	return p.failed
+}
+
+// WaitReady blocks until each of the numWorkers workers started by Run has
+// signalled that it is ready to take tasks, or until ctx is done, in which
+// case it returns ctx.Err(). A producer can call it before submitting so
+// the first burst of tasks does not hit a partially started pool; with
+// nothing to initialize the wait is a few microseconds. It must be called
+// at most once, concurrently with Run.
+func (p *Pool[T]) WaitReady(ctx context.Context) error {
+	for range p.numWorkers {
+		select {
+		case <-p.ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the pool's task counters. It is safe to call
+// concurrently with Submit, Run and the workers, e.g. from a dashboard or a
+// health check, at any time. Each counter is read atomically, but not all at
+// the same instant, so the values are eventually consistent: while tasks
+// move through the pool, Submitted may briefly differ from Completed +
+// Failed + InFlight + QueueDepth. Once Run has returned they add up, except
+// for tasks abandoned on cancellation.
+func (p *Pool[T]) Stats() Stats {
+	depth, _ := p.queueDepth()
+	return Stats{
+		Submitted:  p.counts.submitted.Load(),
+		Completed:  p.counts.completed.Load(),
+		Failed:     p.counts.failed.Load(),
+		InFlight:   p.counts.inFlight.Load(),
+		QueueDepth: depth,
+		Rejected:   p.counts.rejected.Load(),
+
+		InflightBytes: p.budget.inUse(),
+	}
+}
+
+// queueDepth reports how many submitted tasks are waiting for a worker and
+// how many the queue can hold.
+func (p *Pool[T]) queueDepth() (depth, capacity int) {
+	if p.incoming != nil {
+		return int(p.queued.Load()), cap(p.incoming)
+	}
+	return len(p.tasks), cap(p.tasks)
+}
+
+// OutputFiles returns the output files written by the last Run, in the
+// order they were written, with the SHA-256 of what was written to each: one
+// file normally, one per shard when sharding, several per file or shard when
+// rotating (WithRotateSize), and none when writing to standard output. It is
+// only meaningful once Run has returned.
+func (p *Pool[T]) OutputFiles() []OutputFile {
+	return slices.Clone(p.outputFiles)
+}
+
+// WorkerStats returns the per-worker statistics of the last Run, ordered by
+// worker ID. It is only meaningful once Run has returned.
+func (p *Pool[T]) WorkerStats() []WorkerStats {
+	stats := make([]WorkerStats, 0, len(p.workerStats))
+	for _, s := range p.workerStats {
+		if s != nil {
+			stats = append(stats, *s)
+		}
+	}
+	return stats
+}
+
+// tryAddWorker reserves a slot for a new worker if exactly n are running and
+// n is not 0. It fails if the count changed meanwhile, or if every worker has
+// exited, in which case the run is over and nothing may be started.
+func (p *Pool[T]) tryAddWorker(n int32) bool {
+	return n > 0 && p.live.CompareAndSwap(n, n+1)
+}
+
+// tryRetire releases the slot of an idle worker, unless it is the last one
+// running: that worker stays so queued tasks are never left without one.
+func (p *Pool[T]) tryRetire() bool {
+	for {
+		n := p.live.Load()
+		if n <= 1 {
+			return false
+		}
+		if p.live.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// workerExited releases the slot of a worker that stopped because the queue
+// is closed or ctx is cancelled, and closes allExited after the last one.
+func (p *Pool[T]) workerExited() {
+	if p.live.Add(-1) == 0 {
+		close(p.allExited)
+	}
+}
+
+// Run starts the writer and the workers and blocks until every submitted task
+// has been handled (after Close) or ctx is cancelled, and the output file has
+// been flushed and closed. It returns an error if the output could not be
+// written.
+//
+// The output (and dead-letter) files are opened before any worker starts. If
+// that fails, Run returns the error at once without processing anything; the
+// caller should then stop submitting, e.g. by cancelling ctx.
+func (p *Pool[T]) Run(ctx context.Context) error {
+	defer close(p.finished)
+
+	// Shutdown cancels the run through stopRun if its drain times out.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	p.stopMu.Lock()
+	p.stopRun = stop
+	if p.stopRequested {
+		stop()
+	}
+	p.stopMu.Unlock()
+
+	// Open a built-in output up front, so an unwritable destination aborts
+	// the run before any work is computed and thrown away.
+	builtin, isBuiltin := p.out.sink.(builtinSink)
+	if isBuiltin {
+		if err := builtin.open(ctx, p.out, p.logger, p.numWorkers); err != nil {
+			return err
+		}
+	}
+	var (
+		deadLetterFile *os.File
+		err            error
+	)
+	if p.deadLetter != "" {
+		if deadLetterFile, err = os.Create(p.deadLetter); err != nil {
+			p.out.sink.Close()
+			return fmt.Errorf("failed to create dead-letter file '%s': %w", p.deadLetter, err)
+		}
+	}
+
+	// With fail-fast, the first permanent failure cancels the whole run.
+	var onFailure func()
+	if p.failFast {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		var once sync.Once
+		onFailure = func() {
+			once.Do(func() {
+				p.logger.Warn("Fail-fast: stopping the run after the first failed task", "event", "FAIL_FAST")
+				cancel()
+			})
+		}
+	}
+
+	// An output that fails for good (e.g. a full disk) stops the run: the
+	// writer aborts it through out.abort rather than silently dropping
+	// everything computed from then on.
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	out := p.out
+	out.abort = abort
+
+	// resultsChan decouples compute from disk I/O.
+	// Buffering prevents workers from blocking on every single write, while
+	// a bounded buffer makes workers wait for a slow writer (backpressure).
+	resultsBuffer := p.resultsBuffer
+	if resultsBuffer < 0 {
+		_, resultsBuffer = p.queueDepth()
+	}
+	resultsChan := make(chan Result, resultsBuffer)
+	if p.localBuffer > 1 {
+		// The results channel keeps its size; the batches buffer holds
+		// about as many results again.
+		p.batches = make(chan []Result, max(resultsBuffer/p.localBuffer, 1))
+		go unbatch(ctx, p.batches, resultsChan)
+	}
+
+	errorsChan := p.errs
+
+	// done is closed by the writer goroutine when the output file is fully
+	// flushed and closed.
+	done := make(chan struct{})
+	var writeErr error
+
+	// errorsDone is closed by collectErrors once every error has been tallied.
+	errorsDone := make(chan struct{})
+	failures := 0
+
+	// Start the dedicated writer goroutine (owns the shared output resource).
+	go func() {
+		defer close(done)
+		writeErr = sinkWriter(ctx, out, p.logger, out.sink, resultsChan)
+		if isBuiltin {
+			p.outputFiles = builtin.outputFiles()
+		}
+	}()
+
+	// With a dead-letter file, the collector forwards failed tasks to a
+	// dedicated writer that owns that file.
+	var deadLetters chan *taskFailure
+	deadLetterDone := make(chan struct{})
+	var deadLetterErr error
+	if deadLetterFile != nil {
+		deadLetters = make(chan *taskFailure, cap(errorsChan))
+		go func() {
+			defer close(deadLetterDone)
+			deadLetterErr = deadLetterWriter(deadLetterFile, p.logger, deadLetters)
+		}()
+	} else {
+		close(deadLetterDone)
+	}
+
+	// Start the error collector before any worker can report a failure.
+	go collectErrors(p.logger, errorsChan, deadLetters, onFailure, &failures, errorsDone)
+
+	// In priority mode the dispatcher feeds the workers from its heap, and
+	// with fair scheduling from its weighted fair queue; with dependencies
+	// the DAG dispatcher does, and also orders by priority.
+	dagDone := make(chan struct{})
+	var dagErr error
+	switch {
+	case p.dependencies:
+		p.completions = make(chan completion)
+		p.dagDone = make(chan struct{})
+		go func() {
+			defer close(dagDone)
+			dagErr = dagDispatch(ctx, p)
+		}()
+	case p.fair:
+		close(dagDone)
+		go dispatch(ctx, p, newFairQueue[T]())
+	case p.priority:
+		close(dagDone)
+		go dispatch(ctx, p, &jobHeap[T]{})
+	default:
+		close(dagDone)
+	}
+
+	// With round-robin dispatch every worker gets its own lane.
+	if p.dispatch == DispatchRoundRobin {
+		p.lanes = make([]chan job[T], p.numWorkers+1)
+		for w := 1; w <= p.numWorkers; w++ {
+			p.lanes[w] = make(chan job[T])
+		}
+		go roundRobin(ctx, p)
+	}
+
+	// Start worker goroutines.
+	var wg sync.WaitGroup
+	p.live.Store(int32(p.numWorkers))
+	p.allExited = make(chan struct{})
+	runCtx := ctx
+	p.runCtx.Store(&runCtx)
+	p.workerStats = make([]*WorkerStats, p.numWorkers+1)
+	wg.Add(p.numWorkers)
+	for w := 1; w <= p.numWorkers; w++ {
+		p.workerStats[w] = &WorkerStats{ID: w}
+		go worker(ctx, p, w, p.workerStats[w], p.idleTimeout, resultsChan, errorsChan, &wg)
+	}
+
+	// With autoscaling or idle timeouts, a supervisor starts workers while
+	// there is queued work. It stops once every worker has exited, so no
+	// wg.Add can race with the Wait below.
+	if p.maxWorkers > p.numWorkers || p.idleTimeout > 0 {
+		supervisorDone := make(chan struct{})
+		go func() {
+			defer close(supervisorDone)
+			autoscale(ctx, p, resultsChan, errorsChan, &wg)
+		}()
+		<-supervisorDone
+	}
+
+	// Wait until all workers have completed processing.
+	wg.Wait()
+	<-dagDone
+
+	// Close results and errors channels to signal writer and collector to
+	// finish. With local buffers, unbatch closes resultsChan once it has
+	// forwarded the last batch.
+	if p.batches != nil {
+		close(p.batches)
+	} else {
+		close(resultsChan)
+	}
+	close(errorsChan)
+
+	// Wait for writer to flush and close file, and for all errors to be counted.
+	<-done
+	<-errorsDone
+	<-deadLetterDone
+
+	for _, o := range p.outputFiles {
+		p.logger.Info(fmt.Sprintf("Output SHA-256: %s  %s", o.SHA256, o.Path), "path", o.Path, "sha256", o.SHA256)
+	}
+	p.logger.Info(fmt.Sprintf("Failed tasks: %d", failures), "failed", failures)
+	p.failed = failures
+
+	return errors.Join(dagErr, writeErr, deadLetterErr)
+}
+
+// intRange returns the integers from lo up to, but not including, hi.
+func intRange(lo, hi int) []int {
+	r := make([]int, 0, max(hi-lo, 0))
+	for i := lo; i < hi; i++ {
+		r = append(r, i)
+	}
+	return r
+}
@@ -0,0 +1,201 @@
+package workerpool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quietLogger keeps the pool's log out of the test output.
+func quietLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// fastProcessor is the simulated processor without its delay, so tests
+// exercise the real pipeline in milliseconds.
+func fastProcessor() *SimulatedProcessor {
+	proc := NewSimulatedProcessor()
+	proc.DisableDelay()
+	return proc
+}
+
+// runWithin runs p and fails the test if Run does not return within d, so a
+// deadlock fails the test instead of hanging it.
+func runWithin(t *testing.T, p *Pool[string], d time.Duration) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- p.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(d):
+		t.Fatalf("Run did not return within %v", d)
+		return nil
+	}
+}
+
+// submitAll submits tasks 1..n with payloads "data-<ID>" from a goroutine
+// and closes the pool.
+func submitAll(p *Pool[string], n int) {
+	go func() {
+		defer p.Close()
+		for i := 1; i <= n; i++ {
+			p.Submit(StringTask{ID: i, Payload: fmt.Sprintf("data-%d", i)})
+		}
+	}()
+}
+
+// TestResultsBufferOfOne checks that a results channel with a single slot
+// only slows the workers down: every result still reaches the output.
+func TestResultsBufferOfOne(t *testing.T) {
+	const n = 500
+	var out bytes.Buffer
+	p := NewPool[string](8, fastProcessor(),
+		WithOutputWriter(&out),
+		WithResultsBuffer(1),
+		WithLogger(quietLogger()),
+	)
+	submitAll(p, n)
+	if err := runWithin(t, p, 10*time.Second); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != n {
+		t.Fatalf("got %d output lines, want %d", len(lines), n)
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		_, payload, ok := strings.Cut(line, "payload='")
+		if !ok {
+			t.Fatalf("unexpected output line %q", line)
+		}
+		seen[strings.TrimSuffix(payload, "'")] = true
+	}
+	for i := 1; i <= n; i++ {
+		if payload := fmt.Sprintf("data-%d", i); !seen[payload] {
+			t.Errorf("no output line for %s", payload)
+		}
+	}
+	if st := p.Stats(); st.Completed != n {
+		t.Errorf("Stats().Completed = %d, want %d", st.Completed, n)
+	}
+}
+
+// TestStreamingSmallQueue feeds many more tasks than the queue holds while
+// the workers run, the way -input and -stream do: the producer blocks on
+// the full queue and Close must still let Run drain it and return.
+func TestStreamingSmallQueue(t *testing.T) {
+	const n = 2000
+	var out bytes.Buffer
+	p := NewPool[string](4, fastProcessor(),
+		WithOutputWriter(&out),
+		WithQueueSize(4),
+		WithLogger(quietLogger()),
+	)
+	submitAll(p, n)
+	if err := runWithin(t, p, 10*time.Second); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if got := strings.Count(out.String(), "\n"); got != n {
+		t.Errorf("got %d output lines, want %d", got, n)
+	}
+	if st := p.Stats(); st.Completed != n {
+		t.Errorf("Stats().Completed = %d, want %d", st.Completed, n)
+	}
+}
+
+// TestStreamingCancel cancels a run while a producer is blocked on a full
+// queue behind a slow worker. Run must return and the producer must be
+// released with an error instead of waiting for a slot forever.
+func TestStreamingCancel(t *testing.T) {
+	proc := NewSimulatedProcessor() // keeps its delay, so the queue fills up
+	p := NewPool[string](1, proc,
+		WithOutputWriter(io.Discard),
+		WithQueueSize(2),
+		WithLogger(quietLogger()),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	submitErr := make(chan error, 1)
+	go func() {
+		for i := 1; ; i++ {
+			if err := p.SubmitCtx(ctx, StringTask{ID: i, Payload: fmt.Sprintf("data-%d", i)}); err != nil {
+				submitErr <- err
+				return
+			}
+		}
+	}()
+	runErr := make(chan error, 1)
+	go func() { runErr <- p.Run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-runErr:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	select {
+	case err := <-submitErr:
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, ErrPoolClosed) {
+			t.Errorf("SubmitCtx() = %v, want context.Canceled or ErrPoolClosed", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("producer still blocked after cancel")
+	}
+	p.Close()
+}
+
+// TestEmptyInput closes the pool before submitting anything, as the
+// producer does for an empty input file: Run must return nil with nothing
+// written but a CSV header and every counter at zero, whatever dispatches
+// the tasks.
+func TestEmptyInput(t *testing.T) {
+	csvOnly, _ := formatCSV(csvHeader(false))
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"fifo", nil, ""},
+		{"csv", []Option{WithFormat(FormatCSV)}, csvOnly},
+		{"ordered", []Option{WithOrdered(true)}, ""},
+		{"priority", []Option{WithPriorityQueue(true)}, ""},
+		{"fair", []Option{WithFairScheduling(true)}, ""},
+		{"dependencies", []Option{WithDependencies(true)}, ""},
+		{"round-robin", []Option{WithDispatch(DispatchRoundRobin)}, ""},
+		{"local-buffer", []Option{WithLocalBuffer(16)}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			opts := append([]Option{WithOutputWriter(&out), WithLogger(quietLogger())}, tc.opts...)
+			p := NewPool[string](4, fastProcessor(), opts...)
+			p.Close()
+			if err := runWithin(t, p, 10*time.Second); err != nil {
+				t.Fatalf("Run() = %v", err)
+			}
+			if out.String() != tc.want {
+				t.Errorf("output = %q, want %q", out.String(), tc.want)
+			}
+			if st := p.Stats(); st != (Stats{}) {
+				t.Errorf("Stats() = %+v, want all zero", st)
+			}
+			if p.Failed() != 0 {
+				t.Errorf("Failed() = %d, want 0", p.Failed())
+			}
+			for _, ws := range p.WorkerStats() {
+				if ws.Tasks != 0 {
+					t.Errorf("Worker-%d ran %d tasks, want 0", ws.ID, ws.Tasks)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package workerpool
+
+import (
+	"container/heap"
+	"context"
+)
+
+// jobHeap orders queued jobs by descending Task.Priority, falling back to
+// submission order so equal priorities stay FIFO. It implements
+// heap.Interface.
+type jobHeap[T any] []job[T]
+
+func (h jobHeap[T]) Len() int { return len(h) }
+
+func (h jobHeap[T]) Less(i, j int) bool {
+	if h[i].task.Priority != h[j].task.Priority {
+		return h[i].task.Priority > h[j].task.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap[T]) Push(x any) { *h = append(*h, x.(job[T])) }
+
+func (h *jobHeap[T]) Pop() any {
+	old := *h
+	j := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return j
+}
+
+// jobQueue holds the jobs a dispatcher has taken in but not handed out
+// yet, and decides which one goes next: jobHeap by priority, fairQueue by
+// weight. peek returns the next job without removing it; pop removes that
+// same job, and is only called right after a peek.
+type jobQueue[T any] interface {
+	push(j job[T])
+	peek() job[T]
+	pop()
+	len() int
+}
+
+func (h *jobHeap[T]) push(j job[T]) { heap.Push(h, j) }
+func (h *jobHeap[T]) peek() job[T]  { return (*h)[0] }
+func (h *jobHeap[T]) pop()          { heap.Pop(h) }
+func (h *jobHeap[T]) len() int      { return h.Len() }
+
+// dispatch is the dispatcher goroutine used with WithPriorityQueue and
+// WithFairScheduling. It moves submitted jobs from p.incoming into q and
+// hands the one q puts first (the highest-priority one for a jobHeap) to
+// whichever worker is ready first. p.tasks is unbuffered in this mode, so a
+// job only leaves q once a worker has taken it and a later, more urgent job
+// can still overtake everything that is waiting.
+//
+// dispatch closes p.tasks once p.incoming is closed and q is empty, or when
+// ctx is cancelled, so workers exit exactly as with the plain queue.
+func dispatch[T any](ctx context.Context, p *Pool[T], q jobQueue[T]) {
+	defer close(p.tasks)
+
+	in := p.incoming
+	for {
+		// Pull in everything already submitted before choosing, so a waiting
+		// urgent job is not beaten by the current head of the heap.
+	drain:
+		for in != nil {
+			select {
+			case j, ok := <-in:
+				if !ok {
+					in = nil
+					break drain
+				}
+				q.push(j)
+			default:
+				break drain
+			}
+		}
+		if in == nil && q.len() == 0 {
+			return
+		}
+
+		// send stays nil (never ready) while there is nothing to hand out.
+		var send chan<- job[T]
+		var next job[T]
+		if q.len() > 0 {
+			send, next = p.tasks, q.peek()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case j, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			q.push(j)
+		case send <- next:
+			q.pop()
+			p.queued.Add(-1)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package workerpool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Processor performs the actual work for a single task with a payload of
+// type T and returns the processed payload, rendered as the string that ends
+// up in the output line.
+//
+// A single Processor is shared by all workers, so implementations must be
+// safe for concurrent use. Process should return promptly with ctx.Err() once
+// ctx is done; this is how per-task timeouts and shutdown reach the work.
+type Processor[T any] interface {
+	Process(ctx context.Context, t Task[T]) (string, error)
+}
+
+// Initializer is implemented by a Processor that takes settings of its own,
+// such as an endpoint URL, timeouts or credentials, which have no place among
+// the pool's options. Init receives them as raw JSON, so each processor
+// decodes them into its own typed struct. It is called once, through
+// InitProcessor, before the processor is used; an error means the options
+// are invalid.
+type Initializer interface {
+	Init(opts json.RawMessage) error
+}
+
+// InitProcessor passes opts to proc's Init method, so invalid options are
+// caught at startup rather than by the first task. Empty opts (absent, or
+// JSON null) are still passed to a processor with Init, which then applies
+// its defaults; for one without Init they are fine, but any other opts are
+// an error.
+func InitProcessor(proc any, opts json.RawMessage) error {
+	if i, ok := proc.(Initializer); ok {
+		return i.Init(opts)
+	}
+	if !noOptions(opts) {
+		return fmt.Errorf("processor %T takes no options", proc)
+	}
+	return nil
+}
+
+// noOptions reports whether opts is absent or JSON null.
+func noOptions(opts json.RawMessage) bool {
+	opts = bytes.TrimSpace(opts)
+	return len(opts) == 0 || bytes.Equal(opts, []byte("null"))
+}
+
+// workerIDKey is the context key under which workers store their ID.
+type workerIDKey struct{}
+
+// WorkerIDFromContext returns the ID of the worker processing the task whose
+// context is ctx. Processors can use it to keep per-worker state.
+func WorkerIDFromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(workerIDKey{}).(int)
+	return id, ok
+}
+
+// SimulatedProcessor is the default Processor, for string payloads. It
+// simulates compute by waiting a random 150-450ms and returns the payload
+// unchanged, so the output matches the Java implementation for
+// cross-language comparison.
+//
+// Each worker draws delays from its own RNG seeded with seed + workerID, so a
+// fixed seed reproduces the same per-worker delay sequence (and therefore,
+// largely, the same interleaving) on every run.
+//
+// With DisableDelay the payload is returned at once, which leaves only the
+// pool's own overhead to measure. Init can change the range of delays.
+type SimulatedProcessor struct {
+	seed    int64
+	noDelay bool
+
+	// Delays are drawn in whole milliseconds from [minDelay, maxDelay).
+	minDelay, maxDelay time.Duration
+
+	// RNGs are not safe for concurrent use, and the map is shared, so draws
+	// are serialized. Holding the lock only for the draw (not the delay)
+	// keeps contention between workers negligible.
+	mu   sync.Mutex
+	rngs map[int]*rand.Rand
+}
+
+// NewSimulatedProcessor returns a SimulatedProcessor seeded from the clock,
+// so every run is different. Local RNGs avoid global state and deprecation
+// warnings related to rand.Seed in newer Go versions.
+func NewSimulatedProcessor() *SimulatedProcessor {
+	return NewSeededSimulatedProcessor(time.Now().UnixNano())
+}
+
+// NewSeededSimulatedProcessor returns a SimulatedProcessor whose per-worker
+// RNGs are derived deterministically from seed, for reproducible runs.
+func NewSeededSimulatedProcessor(seed int64) *SimulatedProcessor {
+	return &SimulatedProcessor{
+		seed:     seed,
+		minDelay: 150 * time.Millisecond,
+		maxDelay: 450 * time.Millisecond,
+		rngs:     make(map[int]*rand.Rand),
+	}
+}
+
+// simulatedOptions are the options of a SimulatedProcessor, e.g.
+// {"min_delay": "10ms", "max_delay": "50ms"}.
+type simulatedOptions struct {
+	MinDelay string `json:"min_delay"`
+	MaxDelay string `json:"max_delay"`
+}
+
+// Init implements Initializer. Options left out keep the 150ms and 450ms
+// defaults; unknown keys are rejected, so a typo does not go unnoticed. Like
+// DisableDelay, it must be called before the processor is used.
+func (p *SimulatedProcessor) Init(opts json.RawMessage) error {
+	if noOptions(opts) {
+		return nil
+	}
+	var o simulatedOptions
+	dec := json.NewDecoder(bytes.NewReader(opts))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o); err != nil {
+		return fmt.Errorf("simulated processor options: %w", err)
+	}
+	parse := func(name, value string, dst *time.Duration) error {
+		if value == "" {
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("simulated processor options: %s: %w", name, err)
+		}
+		*dst = d
+		return nil
+	}
+	minDelay, maxDelay := p.minDelay, p.maxDelay
+	if err := parse("min_delay", o.MinDelay, &minDelay); err != nil {
+		return err
+	}
+	if err := parse("max_delay", o.MaxDelay, &maxDelay); err != nil {
+		return err
+	}
+	if minDelay < 0 || maxDelay < minDelay {
+		return fmt.Errorf("simulated processor options: need 0 <= min_delay <= max_delay, got %v and %v", minDelay, maxDelay)
+	}
+	p.minDelay, p.maxDelay = minDelay, maxDelay
+	return nil
+}
+
+// DisableDelay turns off the simulated delay: Process returns every payload
+// immediately. It must be called before the processor is used.
+func (p *SimulatedProcessor) DisableDelay() {
+	p.noDelay = true
+}
+
+// Process implements Processor[string].
+func (p *SimulatedProcessor) Process(ctx context.Context, t StringTask) (string, error) {
+	if p.noDelay {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return t.Payload, nil
+	}
+	workerID, _ := WorkerIDFromContext(ctx)
+
+	p.mu.Lock()
+	rng, ok := p.rngs[workerID]
+	if !ok {
+		rng = rand.New(rand.NewSource(p.seed + int64(workerID)))
+		p.rngs[workerID] = rng
+	}
+	delay := p.minDelay
+	if span := int((p.maxDelay - p.minDelay) / time.Millisecond); span > 0 {
+		delay += time.Duration(rng.Intn(span)) * time.Millisecond
+	}
+	p.mu.Unlock()
+
+	// Simulate compute delay (randomized to make concurrency visible in logs).
+	// A timer instead of time.Sleep lets cancellation interrupt the delay.
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-timer.C:
+		return t.Payload, nil
+	}
+}
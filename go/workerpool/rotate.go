@@ -0,0 +1,42 @@
+package workerpool
+
+import (
+	"strconv"
+	"time"
+)
+
+// rotateTimeLayout formats the start of a rotation interval in file names
+// (in UTC), e.g. go-output.20261015T140000Z.txt.
+const rotateTimeLayout = "20060102T150405Z"
+
+// rotatedPath derives the name of a rotated output file from the base path
+// by inserting ".label" before the extension (and before a trailing ".gz"),
+// e.g. target/go-output.txt.gz -> target/go-output.2.txt.gz.
+func rotatedPath(base, label string) string {
+	return labeledPath(base, "."+label)
+}
+
+// rotates reports whether the writer splits its output across several
+// files. Standard output is never rotated.
+func (cfg outputConfig) rotates() bool {
+	return (cfg.rotateSize > 0 || cfg.rotateInterval > 0) && cfg.path != "-"
+}
+
+// filePath is the path of a file the writer starts at t, the n-th (1-based)
+// one of its rotation interval: cfg.path itself when not rotating, numbered
+// for size rotation (go-output.2.txt), named after the start of the interval
+// for time rotation (go-output.20261015T140000Z.txt), and both when the two
+// are combined (go-output.20261015T140000Z.2.txt).
+func (cfg outputConfig) filePath(t time.Time, n int) string {
+	switch {
+	case !cfg.rotates():
+		return cfg.path
+	case cfg.rotateInterval <= 0:
+		return rotatedPath(cfg.path, strconv.Itoa(n))
+	}
+	label := t.Truncate(cfg.rotateInterval).UTC().Format(rotateTimeLayout)
+	if cfg.rotateSize > 0 {
+		label += "." + strconv.Itoa(n)
+	}
+	return rotatedPath(cfg.path, label)
+}
@@ -0,0 +1,99 @@
+package workerpool
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Dispatch selects how queued tasks reach the workers.
+type Dispatch string
+
+const (
+	// DispatchShared lets every worker take the next task from the shared
+	// queue as soon as it is free (the default), so faster workers take
+	// more tasks.
+	DispatchShared Dispatch = "shared"
+
+	// DispatchRoundRobin has a dispatcher goroutine hand tasks to the workers
+	// in turn, each over its own channel. A worker that is still busy when
+	// its turn comes is skipped; when every worker is busy the task goes to
+	// the first one that frees up, and the rotation continues after it.
+	DispatchRoundRobin Dispatch = "round-robin"
+)
+
+// ParseDispatch validates a dispatch mode such as the value of a -dispatch
+// flag.
+func ParseDispatch(s string) (Dispatch, error) {
+	switch d := Dispatch(s); d {
+	case DispatchShared, DispatchRoundRobin:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown dispatch mode %q (want %q or %q)", s, DispatchShared, DispatchRoundRobin)
+}
+
+// roundRobin feeds p.lanes, one unbuffered channel per worker, from the
+// task queue (after the priority or DAG dispatcher, if any). A send only
+// succeeds once the worker is waiting for a task, so nothing is committed to
+// a busy worker. The lanes are closed once the queue is closed and drained,
+// or the run is cancelled, which lets the workers exit.
+func roundRobin[T any](ctx context.Context, p *Pool[T]) {
+	lanes := p.lanes[1:]
+	defer func() {
+		for _, lane := range lanes {
+			close(lane)
+		}
+	}()
+
+	// cases waits on every lane at once, and on ctx, when all are busy.
+	cases := make([]reflect.SelectCase, len(lanes)+1)
+	for i, lane := range lanes {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(lane)}
+	}
+	cases[len(lanes)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+
+	next := 0
+	for {
+		var j job[T]
+		select {
+		case <-ctx.Done():
+			return
+		case queued, ok := <-p.tasks:
+			if !ok {
+				return
+			}
+			j = queued
+		}
+
+		if i, ok := offer(lanes, next, j); ok {
+			next = (i + 1) % len(lanes)
+			continue
+		}
+
+		// Every worker is busy: the first to free up gets the task.
+		v := reflect.ValueOf(j)
+		for i := range lanes {
+			cases[i].Send = v
+		}
+		chosen, _, _ := reflect.Select(cases)
+		if chosen == len(lanes) {
+			return
+		}
+		next = (chosen + 1) % len(lanes)
+	}
+}
+
+// offer tries the lanes in turn, starting at lanes[next], and hands j to the
+// first worker waiting for a task. It returns the index of that lane, or
+// false if every worker is busy.
+func offer[T any](lanes []chan job[T], next int, j job[T]) (int, bool) {
+	for k := range lanes {
+		i := (next + k) % len(lanes)
+		select {
+		case lanes[i] <- j:
+			return i, true
+		default:
+		}
+	}
+	return 0, false
+}
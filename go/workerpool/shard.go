@@ -0,0 +1,152 @@
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ShardBy selects how results are split across output files.
+type ShardBy string
+
+const (
+	// ShardNone writes every result to the single output file (the default).
+	ShardNone ShardBy = "none"
+
+	// ShardWorker gives every worker its own output file, named after the
+	// output path with a "-worker-N" suffix, e.g. go-output-worker-3.txt.
+	ShardWorker ShardBy = "worker"
+)
+
+// ParseShardBy validates a sharding mode such as the value of a -shard-by flag.
+func ParseShardBy(s string) (ShardBy, error) {
+	switch m := ShardBy(s); m {
+	case ShardNone, ShardWorker:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown shard mode %q (want %q or %q)", s, ShardNone, ShardWorker)
+}
+
+// shardPath derives a shard's file name from the base output path by adding
+// "-label" before the extension (and before a trailing ".gz"), e.g.
+// target/go-output.txt.gz -> target/go-output-worker-3.txt.gz.
+func shardPath(base, label string) string {
+	return labeledPath(base, "-"+label)
+}
+
+// labeledPath inserts suffix into base before the extension (and before a
+// trailing ".gz").
+func labeledPath(base, suffix string) string {
+	gz := ""
+	if strings.HasSuffix(base, ".gz") {
+		gz = ".gz"
+		base = strings.TrimSuffix(base, gz)
+	}
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + suffix + ext + gz
+}
+
+// taskShard maps a task ID onto one of n hash partitions (taskID % n, kept
+// non-negative), so consumers partitioning the same way see related tasks in
+// the same file.
+func taskShard(taskID, n int) int {
+	return ((taskID % n) + n) % n
+}
+
+// shardSink splits the results across one lineSink per shard, each
+// writing its own file: key picks a result's shard and label names its file.
+// The shards opened up front by openShards have a file even if empty; any
+// others are created on first use. If such a late file cannot be opened,
+// its shard's results are discarded and Close returns the error.
+type shardSink struct {
+	cfg    outputConfig
+	logger *slog.Logger
+	key    func(Result) int
+	label  func(int) string
+
+	// shards maps a shard to its lineSink, or to nil if its file could not
+	// be opened; errs holds those open errors.
+	shards map[int]*lineSink
+	errs   []error
+}
+
+// openShards opens the shard files for keys before the run starts, so an
+// unwritable destination is reported before any work is done. On error the
+// files opened so far are closed again.
+func openShards(cfg outputConfig, logger *slog.Logger, key func(Result) int, label func(int) string, keys []int) (*shardSink, error) {
+	s := &shardSink{cfg: cfg, logger: logger, key: key, label: label, shards: make(map[int]*lineSink, len(keys))}
+	for _, k := range keys {
+		shard, err := s.open(k)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.shards[k] = shard
+	}
+	return s, nil
+}
+
+func (s *shardSink) open(k int) (*lineSink, error) {
+	shardCfg := s.cfg
+	shardCfg.path = shardPath(s.cfg.path, s.label(k))
+	return openLineSink(shardCfg, s.logger)
+}
+
+// Write adds r to its shard's file, opening the file on first use.
+func (s *shardSink) Write(r Result) error {
+	k := s.key(r)
+	shard, ok := s.shards[k]
+	if !ok {
+		var err error
+		if shard, err = s.open(k); err != nil {
+			s.errs = append(s.errs, err)
+		}
+		s.shards[k] = shard
+	}
+	if shard == nil {
+		return nil
+	}
+	return shard.Write(r)
+}
+
+// Flush writes out every shard's pending batch.
+func (s *shardSink) Flush() error {
+	for _, shard := range s.shards {
+		if shard != nil {
+			if err := shard.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close finishes every shard file, joining their errors with those of the
+// files that could not be opened.
+func (s *shardSink) Close() error {
+	errs := s.errs
+	for _, shard := range s.shards {
+		if shard != nil {
+			if err := shard.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// outputFiles lists the shards in key order, each with its files in the
+// order they were written (several when rotating).
+func (s *shardSink) outputFiles() []OutputFile {
+	var outputs []OutputFile
+	for _, k := range slices.Sorted(maps.Keys(s.shards)) {
+		if shard := s.shards[k]; shard != nil {
+			outputs = append(outputs, shard.outputFiles()...)
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,246 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrSinkClosed is returned by InMemorySink.Write after Close.
+var ErrSinkClosed = errors.New("sink is closed")
+
+// Sink is a destination for results, the output counterpart of a task
+// source: the writer goroutine hands every Result to Write and calls Close
+// once the run is over. The built-in outputs are Sinks too (FileSink,
+// StdoutSink, WriterSink and TCPSink), and WithSink swaps in another one. A
+// new destination (a database, an object store, an in-memory slice in a
+// test) only needs these two methods and never touches the pool's channels.
+//
+// Write and Close are only called from the writer goroutine, one at a time,
+// so a Sink needs no locking of its own for them. Write gets the results in
+// completion order, or in submission order with WithOrdered. An error
+// from Write means the destination failed for good; a Sink that can recover
+// from transient errors should retry inside Write.
+type Sink interface {
+	Write(r Result) error
+	Close() error
+}
+
+// Flusher is implemented by a Sink that buffers results. The writer
+// goroutine calls Flush every batch interval (see WithBatching), so results
+// never sit in the buffer once the stream goes quiet; Close still has to
+// flush whatever is left. An error from Flush is fatal, like one from Write.
+type Flusher interface {
+	Flush() error
+}
+
+// lineWriter is an opened built-in output: one stream of lines (lineSink) or
+// several files fed from the same results (shardSink, formatSink). It marks
+// checkpointed task IDs itself, once their lines are flushed, and lists the
+// files it wrote for OutputFiles.
+type lineWriter interface {
+	Sink
+	Flusher
+	outputFiles() []OutputFile
+}
+
+// builtinSink is a Sink of this package that writes result lines, shaped by
+// the pool's output settings. Run opens it with those settings before any
+// worker starts, so an unwritable destination fails the run before any work
+// is computed and thrown away; workers is the initial number of workers,
+// for ShardWorker.
+type builtinSink interface {
+	lineWriter
+	open(ctx context.Context, cfg outputConfig, logger *slog.Logger, workers int) error
+}
+
+// WithSink sends the results to s instead of the output path. FileSink,
+// StdoutSink, WriterSink and TCPSink take the pool's output settings. Any
+// other Sink gets every Result as is, so the settings that shape output
+// lines and files (path, formats, template, gzip, batch size, sharding,
+// rotation) no longer apply; WithOrdered still does, and a Flusher is
+// flushed every batch interval. Run closes s when it returns, also when it
+// fails to start, unless a built-in sink could not be opened. OutputFiles
+// only lists the files of FileSink.
+func WithSink(s Sink) Option {
+	return func(st *settings) { st.out.sink = s }
+}
+
+// pathSink returns the built-in Sink for an output path given to
+// WithOutputPath.
+func pathSink(path string) Sink {
+	if addr, ok := tcpAddr(path); ok {
+		return TCPSink(addr)
+	}
+	return FileSink(path)
+}
+
+// sinkWriter is the body of the writer goroutine, and the sole caller of
+// the sink's methods. It writes each Result as it arrives (through a
+// reorderBuffer with cfg.ordered, so lines are written in submission order
+// rather than completion order), flushes a Flusher every cfg.batchInterval,
+// and closes sink when resultsChan is closed, or, after writing the results
+// already buffered, when ctx is cancelled, so completed work is not lost.
+//
+// The first Write or Flush error is fatal: cfg.abort stops the run so the
+// workers stop, the results still arriving are discarded (so workers never
+// block) and the error is returned; sink is still closed. A Close error is
+// returned if nothing failed before, and logged otherwise.
+//
+// With cfg.checkpoint, the IDs of successful results are marked done once
+// Write accepted them (a built-in sink marks them once they are flushed),
+// and the checkpoint is saved every batch interval and at the end.
+func sinkWriter(ctx context.Context, cfg outputConfig, logger *slog.Logger, sink Sink, resultsChan <-chan Result) (err error) {
+	// The built-in sinks' errors already say what failed.
+	_, builtin := sink.(builtinSink)
+	flusher, _ := sink.(Flusher)
+
+	saveCheckpoint := func() {
+		if cfg.checkpoint == nil {
+			return
+		}
+		if serr := cfg.checkpoint.save(); serr != nil {
+			logger.Warn(serr.Error())
+		}
+	}
+	defer func() {
+		if cerr := sink.Close(); cerr != nil {
+			if !builtin {
+				cerr = fmt.Errorf("failed to close result sink: %w", cerr)
+			}
+			if err == nil {
+				err = cerr
+			} else {
+				logger.Error(cerr.Error())
+			}
+		}
+		saveCheckpoint()
+	}()
+
+	broken := false
+	fail := func(e error) {
+		err = e
+		broken = true
+		logger.Warn("Output failed: stopping the run, further results are discarded", "event", "OUTPUT_FAILED")
+		if cfg.abort != nil {
+			cfg.abort(err)
+		}
+	}
+	write := func(r Result) {
+		if broken {
+			return
+		}
+		if werr := sink.Write(r); werr != nil {
+			if !builtin {
+				werr = fmt.Errorf("failed to write result of Task-%d to sink: %w", r.TaskID, werr)
+			}
+			fail(werr)
+			return
+		}
+		if !builtin && cfg.checkpoint != nil && r.Err == nil {
+			cfg.checkpoint.markDone([]int{r.TaskID})
+		}
+	}
+	flush := func() {
+		if broken || flusher == nil {
+			return
+		}
+		if ferr := flusher.Flush(); ferr != nil {
+			if !builtin {
+				ferr = fmt.Errorf("failed to flush result sink: %w", ferr)
+			}
+			fail(ferr)
+		}
+	}
+
+	emit := write
+	if cfg.ordered {
+		rb := &reorderBuffer{pending: make(map[int]Result)}
+		emit = func(r Result) { rb.add(r, write) }
+
+		// Runs before the deferred Close, so held results reach the sink.
+		defer rb.flush(write)
+	}
+
+	var tick <-chan time.Time
+	if cfg.batchInterval > 0 && (flusher != nil || cfg.checkpoint != nil) {
+		ticker := time.NewTicker(cfg.batchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case r, ok := <-resultsChan:
+			if !ok {
+				return err
+			}
+			emit(r)
+		case <-tick:
+			flush()
+			saveCheckpoint()
+		case <-ctx.Done():
+			// Write whatever is already buffered without waiting for more.
+			for {
+				select {
+				case r, ok := <-resultsChan:
+					if !ok {
+						return err
+					}
+					emit(r)
+				default:
+					return err
+				}
+			}
+		}
+	}
+}
+
+// InMemorySink is a Sink that keeps every Result in memory, for tests and
+// programs that embed the pool and want to inspect its output without
+// touching the file system:
+//
+//	sink := &workerpool.InMemorySink{}
+//	p := workerpool.NewPool[string](4, proc, workerpool.WithSink(sink))
+//	// ... Submit, Close, Run
+//	for _, r := range sink.Results() { ... }
+//
+// The zero value is ready to use. It is safe for concurrent use, so Results
+// may also be read while the run is in progress, e.g. from an Observer.
+type InMemorySink struct {
+	mu      sync.Mutex
+	results []Result
+	closed  bool
+}
+
+// Write appends r to the results. It fails with ErrSinkClosed after Close.
+func (s *InMemorySink) Write(r Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSinkClosed
+	}
+	s.results = append(s.results, r)
+	return nil
+}
+
+// Close marks the sink closed; the results stay available.
+func (s *InMemorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Results returns a copy of the results written so far, in the order they
+// were written. Once Run has returned, and so closed the sink, it holds the
+// whole output of the run.
+func (s *InMemorySink) Results() []Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.results)
+}
@@ -0,0 +1,84 @@
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestInMemorySink runs a pool into an InMemorySink with ordered output and
+// checks that it collected exactly the submitted tasks, in order, and was
+// closed at the end.
+func TestInMemorySink(t *testing.T) {
+	const n = 200
+	sink := &InMemorySink{}
+	p := NewPool[string](4, fastProcessor(),
+		WithSink(sink),
+		WithOrdered(true),
+		WithLogger(quietLogger()),
+	)
+	submitAll(p, n)
+	if err := runWithin(t, p, 10*time.Second); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	results := sink.Results()
+	if len(results) != n {
+		t.Fatalf("sink got %d results, want %d", len(results), n)
+	}
+	for i, r := range results {
+		if r.TaskID != i+1 || r.Payload != fmt.Sprintf("data-%d", i+1) || r.Err != nil || r.Attempts != 1 {
+			t.Errorf("result %d = {TaskID:%d Payload:%q Err:%v Attempts:%d}, want {TaskID:%d Payload:\"data-%d\" Err:<nil> Attempts:1}",
+				i, r.TaskID, r.Payload, r.Err, r.Attempts, i+1, i+1)
+		}
+		if r.WorkerID < 1 || r.WorkerID > 4 {
+			t.Errorf("result %d has WorkerID %d, want 1..4", i, r.WorkerID)
+		}
+	}
+	if len(p.OutputFiles()) != 0 {
+		t.Errorf("OutputFiles() = %v, want none with a sink", p.OutputFiles())
+	}
+	if err := sink.Write(Result{}); !errors.Is(err, ErrSinkClosed) {
+		t.Errorf("Write after Run = %v, want ErrSinkClosed", err)
+	}
+}
+
+// TestFileSinkShards checks that a FileSink given to WithSink still takes
+// the pool's output settings: with WithShards every shard file is written
+// and listed in OutputFiles.
+func TestFileSinkShards(t *testing.T) {
+	const n = 90
+	path := filepath.Join(t.TempDir(), "out.txt")
+	p := NewPool[string](4, fastProcessor(),
+		WithSink(FileSink(path)),
+		WithShards(3),
+		WithLogger(quietLogger()),
+	)
+	submitAll(p, n)
+	if err := runWithin(t, p, 10*time.Second); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	outputs := p.OutputFiles()
+	if len(outputs) != 3 {
+		t.Fatalf("OutputFiles() = %v, want 3 shard files", outputs)
+	}
+	lines := 0
+	for k, o := range outputs {
+		if want := shardPath(path, fmt.Sprintf("shard-%d", k)); o.Path != want {
+			t.Errorf("output %d is %s, want %s", k, o.Path, want)
+		}
+		data, err := os.ReadFile(o.Path)
+		if err != nil {
+			t.Fatalf("reading shard file: %v", err)
+		}
+		lines += strings.Count(string(data), "\n")
+	}
+	if lines != n {
+		t.Errorf("shard files hold %d lines, want %d", lines, n)
+	}
+}
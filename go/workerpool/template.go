@@ -0,0 +1,51 @@
+package workerpool
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ParseTemplate parses s as a text/template for output lines, such as the
+// value of a -template flag, e.g. "{{.WorkerID}},{{.TaskID}},{{.Payload}}".
+// The template is executed with each Result, so it can use every exported
+// Result field: TaskID, WorkerID, Payload, Timestamp, Err, Attempts and
+// Duration.
+//
+// Besides syntax errors, the template is checked by executing it once on a
+// sample Result, so a misspelt field such as {{.Task}} is reported here
+// rather than on every line of the run.
+func ParseTemplate(s string) (*template.Template, error) {
+	tmpl, err := template.New("result").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	sample := Result{TaskID: 1, WorkerID: 1, Payload: "data-1", Timestamp: time.Now(), Attempts: 1}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// WithTemplate renders every output line with tmpl (see ParseTemplate)
+// instead of a built-in format; a newline is added unless the template ends
+// with one. It replaces WithFormat and WithFormats, and WithVerbose has no
+// effect: the template decides which fields appear. A line whose template
+// fails to execute is logged and skipped. nil keeps the built-in formats.
+func WithTemplate(tmpl *template.Template) Option {
+	return func(s *settings) { s.out.template = tmpl }
+}
+
+// formatTemplate renders r with tmpl as a single newline-terminated line.
+func formatTemplate(tmpl *template.Template, r Result) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, r); err != nil {
+		return "", fmt.Errorf("template: %w", err)
+	}
+	if !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
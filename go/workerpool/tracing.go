@@ -0,0 +1,36 @@
+package workerpool
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startTaskSpan starts the span covering one task on a worker, as a child of
+// ctx. With a nil tracer it returns ctx unchanged and a nil span, so untraced
+// pools pay nothing but the check.
+func startTaskSpan(ctx context.Context, tracer trace.Tracer, taskID, workerID int) (context.Context, trace.Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	return tracer.Start(ctx, "process task", trace.WithAttributes(
+		attribute.Int("task.id", taskID),
+		attribute.Int("worker.id", workerID),
+	))
+}
+
+// endTaskSpan records the outcome of the task on span and ends it. A nil
+// span is ignored.
+func endTaskSpan(span trace.Span, attempts int, err error) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(attribute.Int("task.attempts", attempts))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,71 @@
+package workerpool
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidTask wraps the error of a task that a Validator refused.
+var ErrInvalidTask = errors.New("invalid task")
+
+// Validator checks a task before it is queued and returns a non-nil error
+// to refuse it; see WithValidator.
+type Validator[T any] func(Task[T]) error
+
+// NoValidation is the default Validator: it accepts every task.
+func NoValidation[T any](Task[T]) error {
+	return nil
+}
+
+// MaxPayloadLength returns a Validator refusing string payloads longer than
+// max bytes.
+func MaxPayloadLength(max int) Validator[string] {
+	return func(t StringTask) error {
+		if len(t.Payload) > max {
+			return fmt.Errorf("payload is %d bytes, over the %d-byte limit", len(t.Payload), max)
+		}
+		return nil
+	}
+}
+
+// WithValidator checks every task with v in Submit, SubmitCtx and
+// TrySubmit, before it is queued. A refused task never reaches a worker: it
+// is reported like a task that failed after its retries (logged, counted in
+// Failed and written to the WithDeadLetter file) with an error wrapping
+// ErrInvalidTask, and takes no queue slot or sequence number.
+//
+// v must be a Validator for the Pool's payload type, or Submit panics.
+// Refused tasks are handed to the error collector that Run starts, so
+// submitting many invalid tasks before Run blocks like a full queue.
+func WithValidator[T any](v Validator[T]) Option {
+	return func(s *settings) {
+		s.validator = func(t any) error { return v(t.(Task[T])) }
+	}
+}
+
+// Validate runs the WithValidator Validator on t without queueing it, for
+// callers that hold a task back before submitting it, such as a spill
+// queue. A refused task is reported as failed just like one refused on
+// submission, and the error, wrapping ErrInvalidTask, is returned; the task
+// must then not be submitted. A task that passes is checked again when it
+// is submitted.
+func (p *Pool[T]) Validate(t Task[T]) error {
+	return p.validate(t)
+}
+
+// validate runs the Validator, if any, on t. An invalid task is reported on
+// p.errs as a taskFailure with no attempts, so the error collector treats
+// it like any other failed task; the error is also returned.
+func (p *Pool[T]) validate(t Task[T]) error {
+	if p.validator == nil {
+		return nil
+	}
+	err := p.validator(t)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%w: %w", ErrInvalidTask, err)
+	p.counts.rejected.Add(1)
+	p.errs <- &taskFailure{taskID: t.ID, payload: t.Payload, err: err}
+	return err
+}
@@ -0,0 +1,414 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// retryBaseDelay is the backoff before the first retry. Each further retry
+// doubles it (100ms, 200ms, 400ms, ...).
+const retryBaseDelay = 100 * time.Millisecond
+
+// ErrExpired is the Result error of a task whose Deadline had already passed
+// when a worker got to it, so it was dropped without being processed.
+var ErrExpired = errors.New("task deadline expired")
+
+// errPanicked is wrapped by every TaskPanicError. Such failures are not
+// retried, since a panic usually reproduces on every try.
+var errPanicked = errors.New("processor panicked")
+
+// taskFailure is the error a worker reports on errorsChan for a task that
+// still failed after all retries, or that was refused before processing. It
+// keeps the task's ID and payload so the error collector can hand them to
+// the dead-letter writer.
+type taskFailure struct {
+	taskID   int
+	payload  any
+	attempts int
+	err      error
+}
+
+func (f *taskFailure) Error() string {
+	if f.attempts == 0 {
+		// Refused by the Validator or the circuit breaker, or skipped for a
+		// failed dependency.
+		return fmt.Sprintf("Task-%d not processed: %v", f.taskID, f.err)
+	}
+	return fmt.Sprintf("Task-%d failed after %d attempt(s): %v", f.taskID, f.attempts, f.err)
+}
+
+func (f *taskFailure) Unwrap() error { return f.err }
+
+// processTask runs a single processing attempt for task through proc. If
+// taskTimeout > 0 the attempt runs under its own context.WithTimeout derived
+// from ctx, so a hung task cannot stall the worker forever. An error is
+// returned as a TaskTimeoutError or ProcessorError (see classify).
+//
+// A panic in proc is recovered, logged with its stack, and returned as a
+// TaskPanicError that carries the panic value and stack, so one bad payload
+// cannot crash the run or leave the output file half-written.
+func processTask[T any](ctx context.Context, logger *slog.Logger, proc Processor[T], task Task[T], taskTimeout time.Duration) (output string, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			// The error message leaves the stack out, so it is logged here,
+			// once, instead of with every report of the failure.
+			stack := debug.Stack()
+			logger.Error(fmt.Sprintf("recovered panic while processing Task-%d: %v", task.ID, v),
+				"task", task.ID, "event", "PANICKED", "stack", string(stack))
+			err = &TaskPanicError[T]{Task: task, Value: v, Stack: stack}
+		}
+	}()
+
+	attemptCtx := ctx
+	if taskTimeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, taskTimeout)
+		defer cancel()
+	}
+	output, err = proc.Process(attemptCtx, task)
+	return output, classify(ctx, attemptCtx, task, taskTimeout, err)
+}
+
+// runAttempt runs one processing attempt of task and records its outcome
+// with the circuit breaker. With WithMaxConcurrency it first takes a token
+// from p.sem, waiting while every token is held, and hands it back once the
+// attempt is over, so no more than that many attempts run at once across
+// all workers. The task timeout only starts once the token is held. Waiting
+// gives up with ctx.Err() when ctx (the run's context) is cancelled.
+// timeout is the attempt's task timeout (see escalate).
+func (p *Pool[T]) runAttempt(ctx, taskCtx context.Context, logger *slog.Logger, task Task[T], timeout time.Duration) (string, error) {
+	if p.sem != nil {
+		select {
+		case p.sem <- struct{}{}:
+			defer func() { <-p.sem }()
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	output, err := processTask(taskCtx, logger, p.proc, task, timeout)
+	p.recordAttempt(ctx, err)
+	return output, err
+}
+
+// escalate returns the timeout for the attempt after one that failed with
+// err under timeout: multiplied by the WithTimeoutEscalation factor if the
+// attempt timed out, unchanged otherwise.
+func (p *Pool[T]) escalate(timeout time.Duration, err error) time.Duration {
+	if p.escalation <= 1 || timeout <= 0 || errorType(err) != "timeout" {
+		return timeout
+	}
+	return time.Duration(float64(timeout) * p.escalation)
+}
+
+// worker pulls tasks from the pool's queue, processes each one with the
+// pool's Processor, and sends a Result per task to resultsChan. Formatting is
+// left to the writer. Observers are notified as each task finishes. Every
+// log line goes through p.logger with "worker", "task" and "event" fields; the
+// per-task PICKED/COMPLETED lines are debug level, the rest info or above.
+// Each finished task is also counted in stats, which only this worker writes.
+//
+// Concurrency model (Go-idiomatic):
+// - Channels provide safe synchronization for task distribution.
+// - Workers terminate naturally when the tasks channel is closed and drained.
+// - No locks are required for task queue access because channels are concurrency-safe.
+//
+// Pausing:
+//   - Before taking each task the worker checks whether the pool is paused
+//     (see Pool.Pause) and, if so, waits for Resume or cancellation. A task
+//     already picked up is always finished first.
+//
+// Idle exit:
+//   - If idleTimeout > 0 the worker exits voluntarily when no task arrives
+//     within that time, unless it is the last running worker (see
+//     tryRetire), which keeps waiting so queued tasks always have a taker.
+//     The autoscaler uses this to shed workers it added once the queue
+//     drains, and WithIdleTimeout applies it to every worker.
+//
+// Cancellation:
+//   - Every blocking point (receiving a task, the rate limiter, processing,
+//     the retry backoff and sending the result) selects on ctx.Done(), so a
+//     cancelled context makes the worker abandon its current task and
+//     return promptly.
+//   - If a task timeout is set, each attempt runs under its own
+//     context.WithTimeout. With WithTimeoutEscalation, a retry after a
+//     timeout gets a longer one (see escalate).
+//   - With a tracer (WithTracer), each task and its retries run under a span
+//     derived from ctx, so spans nest under the caller's run span.
+//
+// Retries:
+//   - A failed attempt is retried up to p.retries times with exponential
+//     backoff starting at retryBaseDelay. The attempt count and the time
+//     spent on the task are recorded in the Result.
+//   - With a circuit breaker (WithCircuitBreaker), every attempt first asks
+//     it for permission; while it is open the task fails at once with
+//     ErrBreakerOpen and is not retried.
+//   - A panicking Processor is recovered per task (see processTask); the
+//     task fails without retries and the worker carries on.
+//   - A task that still fails produces a failed Result (rendered as a
+//     TIMEOUT/FAILED line) and the worker moves on, so failed tasks still
+//     count as handled.
+//
+// Deadlines:
+//   - A task whose Deadline has passed by the time it would be processed is
+//     dropped: it produces an EXPIRED Result (Err is ErrExpired) without
+//     touching the Processor. Like any other result it is observed and
+//     written, and the worker moves on.
+//
+// Error handling:
+//   - A goroutine cannot return an error to its caller, so permanent task
+//     failures are sent to errorsChan, wrapped with the task ID. Run drains
+//     errorsChan and closes it only after every worker has finished, so a send
+//     here can never hit a closed channel.
+//   - File I/O is handled centrally by a dedicated writer goroutine.
+func worker[T any](ctx context.Context, p *Pool[T], workerID int, stats *WorkerStats, idleTimeout time.Duration, resultsChan chan<- Result, errorsChan chan<- error, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// An idle worker has already given up its slot in p.live.
+	retired := false
+	defer func() {
+		if !retired {
+			p.workerExited()
+		}
+	}()
+
+	logger := p.logger.With("worker", workerID)
+	logger.Info(fmt.Sprintf("Worker-%d STARTED", workerID), "event", "STARTED")
+	defer logger.Info(fmt.Sprintf("Worker-%d FINISHED", workerID), "event", "FINISHED")
+
+	// Tell WaitReady this worker can take tasks. The send never blocks:
+	// workers added later by the autoscaler may find no free slot.
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+
+	// Processors can look up which worker is calling them.
+	procCtx := context.WithValue(ctx, workerIDKey{}, workerID)
+
+	// Tasks come from the shared queue, or from this worker's own lane with
+	// round-robin dispatch.
+	queue := p.tasks
+	if p.lanes != nil {
+		queue = p.lanes[workerID]
+	}
+
+	// idle fires when no task arrives within idleTimeout. It stays nil (never
+	// fires) for workers that live until the queue is closed.
+	var idleTimer *time.Timer
+	var idle <-chan time.Time
+	if idleTimeout > 0 {
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	// With WithLocalBuffer, results are collected in local and sent on in
+	// batches: when it is full, when flushTick finds it waiting, and when the
+	// worker exits.
+	var local *resultBuffer
+	var flushTick <-chan time.Time
+	if p.batches != nil {
+		local = newResultBuffer(p.batches, p.localBuffer)
+		defer local.flush(ctx, logger)
+		ticker := time.NewTicker(localFlushInterval)
+		defer ticker.Stop()
+		flushTick = ticker.C
+	}
+
+	for {
+		// While the pool is paused, wait here rather than take another task.
+		// The idle timer is not running, so waiting does not count as idle.
+		if resumed := p.pauseWait(); resumed != nil {
+			logger.Info(fmt.Sprintf("Worker-%d paused", workerID), "event", "PAUSED")
+			if local != nil {
+				local.flush(ctx, logger)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-resumed:
+			}
+			logger.Info(fmt.Sprintf("Worker-%d resumed", workerID), "event", "RESUMED")
+		}
+
+		if idleTimer != nil {
+			idleTimer.Reset(idleTimeout)
+		}
+
+		// The tick only matters while results are waiting to be sent.
+		var tick <-chan time.Time
+		if local != nil && len(local.results) > 0 {
+			tick = flushTick
+		}
+
+		var j job[T]
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			local.flush(ctx, logger)
+			continue
+		case <-idle:
+			if retired = p.tryRetire(); !retired {
+				continue
+			}
+			logger.Info(fmt.Sprintf("Worker-%d idle for %v, exiting", workerID, idleTimeout), "event", "IDLE")
+			return
+		case next, ok := <-queue:
+			if !ok {
+				return
+			}
+			j = next
+		}
+		task := j.task
+		p.counts.inFlight.Add(1)
+
+		logger.Debug(fmt.Sprintf("Worker-%d Picked Task-%d", workerID, task.ID), "task", task.ID, "event", "PICKED")
+
+		// The limiter is shared by all workers, so this caps the pool's total
+		// throughput. Wait returns early with an error if ctx is cancelled.
+		if p.limiter != nil {
+			if err := p.limiter.Wait(ctx); err != nil {
+				p.counts.inFlight.Add(-1)
+				p.budget.release(j.size)
+				abandoned(logger, workerID, task.ID, err)
+				return
+			}
+		}
+
+		// With WithTracer the task runs under its own span; Processors see it
+		// in their context.
+		taskCtx, span := startTaskSpan(procCtx, p.tracer, task.ID, workerID)
+
+		start := time.Now()
+		attempt := 1
+		var output string
+		var err error
+		if j.skip != nil {
+			// A dependency did not succeed, so this task must not run.
+			logger.Warn(fmt.Sprintf("Worker-%d Skipped Task-%d: %v", workerID, task.ID, j.skip),
+				"task", task.ID, "event", "SKIPPED")
+			attempt, output, err = 0, fmt.Sprint(task.Payload), j.skip
+		} else if !task.Deadline.IsZero() && start.After(task.Deadline) {
+			// Stale work is worthless; skip it instead of wasting compute.
+			logger.Warn(fmt.Sprintf("Worker-%d Dropped Task-%d: deadline passed %v ago", workerID, task.ID, start.Sub(task.Deadline).Round(time.Millisecond)),
+				"task", task.ID, "event", "EXPIRED")
+			attempt, output, err = 0, fmt.Sprint(task.Payload), ErrExpired
+		} else if !p.breaker.allow() {
+			// Spare the failing downstream; the task goes to the dead letters.
+			attempt, err = 0, ErrBreakerOpen
+		} else {
+			output, err = p.runAttempt(ctx, taskCtx, logger, task, p.taskTimeout)
+		}
+		timeout := p.taskTimeout
+		for err != nil && err != ErrExpired && ctx.Err() == nil && attempt <= p.retries && !errors.Is(err, errPanicked) && !errors.Is(err, ErrBreakerOpen) && !errors.Is(err, ErrPayloadDecode) && j.skip == nil {
+			backoff := retryBaseDelay << (attempt - 1)
+			logger.Info(fmt.Sprintf("Worker-%d Retrying Task-%d in %v (attempt %d failed: %v)", workerID, task.ID, backoff, attempt, err),
+				"task", task.ID, "event", "RETRYING", "attempt", attempt)
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+				if !p.breaker.allow() {
+					err = fmt.Errorf("%w (last error: %v)", ErrBreakerOpen, err)
+					break
+				}
+				if next := p.escalate(timeout, err); next != timeout {
+					logger.Info(fmt.Sprintf("Worker-%d Escalating Task-%d timeout to %v", workerID, task.ID, next),
+						"task", task.ID, "event", "ESCALATED", "attempt", attempt+1)
+					timeout = next
+				}
+				attempt++
+				output, err = p.runAttempt(ctx, taskCtx, logger, task, timeout)
+			}
+		}
+		p.counts.inFlight.Add(-1)
+		p.budget.release(j.size)
+		if ctx.Err() != nil {
+			endTaskSpan(span, attempt, ctx.Err())
+			abandoned(logger, workerID, task.ID, ctx.Err())
+			return
+		}
+		endTaskSpan(span, attempt, err)
+		if err != nil {
+			p.counts.failed.Add(1)
+		} else {
+			p.counts.completed.Add(1)
+		}
+
+		// A failed task has no processed output, so its input payload is kept.
+		if err != nil && err != ErrExpired {
+			output = fmt.Sprint(task.Payload)
+			errorsChan <- &taskFailure{taskID: task.ID, payload: task.Payload, attempts: attempt, err: err}
+		}
+		res := Result{
+			TaskID:    task.ID,
+			WorkerID:  workerID,
+			Payload:   output,
+			Timestamp: time.Now(),
+			Err:       err,
+			Attempts:  attempt,
+			Meta:      task.Meta,
+			seq:       j.seq,
+		}
+		elapsed := res.Timestamp.Sub(start)
+		res.Duration = elapsed
+		stats.Tasks++
+		stats.Busy += elapsed
+		for _, o := range p.observers {
+			o.TaskFinished(res, elapsed)
+		}
+
+		// Send result to the writer goroutine. This separates compute from I/O,
+		// and avoids multiple goroutines writing to the file concurrently.
+		// The writer may already have stopped if ctx was cancelled, so the send
+		// must not block forever. With a local buffer the result may wait
+		// there for the rest of its batch.
+		if local != nil {
+			if !local.add(ctx, logger, res) {
+				return
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				abandoned(logger, workerID, task.ID, ctx.Err())
+				return
+			case resultsChan <- res:
+			}
+		}
+
+		// With dependencies, tell the dispatcher so dependents can start.
+		if p.completions != nil {
+			select {
+			case p.completions <- completion{taskID: task.ID, ok: err == nil}:
+			case <-p.dagDone:
+			case <-ctx.Done():
+			}
+		}
+
+		logger.Debug(fmt.Sprintf("Worker-%d Completed Task-%d", workerID, task.ID), "task", task.ID, "event", "COMPLETED")
+	}
+}
+
+// recordAttempt feeds the outcome of a processing attempt to the circuit
+// breaker, if any. Attempts cut short by cancelling the run, and payloads
+// that could not be decoded, say nothing about the downstream and are not
+// counted.
+func (p *Pool[T]) recordAttempt(ctx context.Context, err error) {
+	if ctx.Err() == nil && !errors.Is(err, ErrPayloadDecode) {
+		p.breaker.record(err == nil)
+	}
+}
+
+// abandoned logs that a worker gave up on a task because the run was
+// cancelled.
+func abandoned(logger *slog.Logger, workerID, taskID int, err error) {
+	logger.Info(fmt.Sprintf("Worker-%d Abandoned Task-%d: %v", workerID, taskID, err), "task", taskID, "event", "ABANDONED")
+}
@@ -0,0 +1,635 @@
+package workerpool
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// minWriteBuffer is the smallest buffer size accepted for the output
+// buffered writer; smaller sizes fall back to the bufio default (4096 bytes).
+const minWriteBuffer = 512
+
+// reorderBuffer restores submission order for results that complete out of
+// order. Results are held keyed by sequence number and released in contiguous
+// runs as soon as the next expected one arrives.
+//
+// Memory grows with the number of results that finished ahead of the oldest
+// unfinished task; in the worst case (the first task is the slowest) every
+// other result is buffered until it completes.
+type reorderBuffer struct {
+	next    int
+	pending map[int]Result
+}
+
+// add stores r and writes every result that is now in sequence.
+func (b *reorderBuffer) add(r Result, write func(Result)) {
+	b.pending[r.seq] = r
+	for {
+		next, ok := b.pending[b.next]
+		if !ok {
+			return
+		}
+		delete(b.pending, b.next)
+		write(next)
+		b.next++
+	}
+}
+
+// flush writes all remaining results in sequence order, skipping gaps left
+// by tasks that never produced a result (e.g. abandoned on cancellation).
+func (b *reorderBuffer) flush(write func(Result)) {
+	for _, seq := range slices.Sorted(maps.Keys(b.pending)) {
+		write(b.pending[seq])
+	}
+	clear(b.pending)
+}
+
+// sortedBatch holds the formatted lines of one output batch with their task
+// IDs, so the batch can be written in ascending ID order (see
+// WithSortedBatches). Only a batch's worth of lines is ever held, unlike the
+// reorderBuffer.
+type sortedBatch struct {
+	lines []batchLine
+}
+
+type batchLine struct {
+	id   int
+	line string
+}
+
+func (b *sortedBatch) add(id int, line string) {
+	b.lines = append(b.lines, batchLine{id, line})
+}
+
+// appendTo appends the held lines to dst sorted by task ID, keeping the
+// arrival order of equal IDs, and empties the batch.
+func (b *sortedBatch) appendTo(dst []byte) []byte {
+	slices.SortStableFunc(b.lines, func(x, y batchLine) int { return cmp.Compare(x.id, y.id) })
+	for _, l := range b.lines {
+		dst = append(dst, l.line...)
+	}
+	clear(b.lines)
+	b.lines = b.lines[:0]
+	return dst
+}
+
+// outputConfig holds the writer settings chosen through Pool options.
+type outputConfig struct {
+	path string
+
+	// sink receives the results; see WithSink and sinkWriter. NewPool picks
+	// FileSink, StdoutSink or TCPSink from path if no option set one.
+	sink Sink
+
+	format  Format
+	verbose bool
+
+	// template, if set, renders every line instead of format; see
+	// WithTemplate.
+	template *template.Template
+
+	// formats lists every format when WithFormats asked for more than one;
+	// each gets its own file (see formatSink).
+	formats []Format
+
+	ordered bool
+	gzip    bool
+	shardBy ShardBy
+	shards  int
+	append  bool
+
+	// writeBuffer is the bufio buffer size; below minWriteBuffer the bufio
+	// default is used.
+	writeBuffer int
+
+	// batchSize > 0 enables batched writes; see lineSink. sortBatches writes
+	// each batch in task ID order.
+	batchSize     int
+	batchInterval time.Duration
+	sortBatches   bool
+
+	// checkpoint, if set, records flushed successful task IDs; see lineSink.
+	checkpoint *checkpoint
+
+	// rotateSize > 0 starts a new numbered file once the current one holds
+	// at least that many bytes; rotateInterval > 0 starts a new timestamped
+	// file once per interval of wall-clock time. See lineSink.
+	rotateSize     int64
+	rotateInterval time.Duration
+
+	// abort, set by Run, cancels the run when the output fails for good;
+	// see sinkWriter.
+	abort context.CancelCauseFunc
+}
+
+// OutputFile describes an output file written by a Run: its path and the
+// size and SHA-256 digest of the bytes the run wrote to it. The digest is of
+// the bytes on disk (after gzip compression, if enabled). With WithAppend it
+// only covers what this run appended, not what the file held before.
+type OutputFile struct {
+	Path   string
+	Bytes  int64
+	SHA256 string
+}
+
+// digestWriter hashes and counts everything written through it. lineSink
+// tees the file's bytes into one, so the digest is ready when the file is
+// closed without reading it back.
+type digestWriter struct {
+	h hash.Hash
+	n int64
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.h.Write(p)
+}
+
+// formatLine renders r as one output line: with the template if one is set,
+// and in cfg.format otherwise.
+func (cfg outputConfig) formatLine(r Result) (string, error) {
+	if cfg.template != nil {
+		return formatTemplate(cfg.template, r)
+	}
+	return formatResult(cfg.format, cfg.verbose, r)
+}
+
+// fatalWriteError reports whether err, returned while writing output, means
+// the destination cannot take any more data: the disk or quota is full, the
+// file is too large, the file system is read-only or the device failed.
+// Retrying cannot help, so such an error stops the run instead of computing
+// results that would only be thrown away.
+func fatalWriteError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT, syscall.EFBIG, syscall.EROFS, syscall.EIO} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// openOutput opens the output file for the n-th file started at t (see
+// outputConfig.filePath; 1 is the first), truncating it or, with cfg.append,
+// appending to it. "-" means standard output, which is returned as is and
+// never closed by lineSink.
+func openOutput(cfg outputConfig, t time.Time, n int) (*os.File, error) {
+	if cfg.path == "-" {
+		return os.Stdout, nil
+	}
+	path := cfg.filePath(t, n)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if cfg.append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file '%s': %w", path, err)
+	}
+	return file, nil
+}
+
+// FileSink is the Sink that writes result lines to the file at path, the
+// default destination (see WithOutputPath). The pool's output options shape
+// it: format, template, gzip, append, buffering, batching, sharding, several
+// formats and rotation. Run opens the file before any worker starts, so an
+// unwritable path fails the run up front. "-" is the same as StdoutSink.
+func FileSink(path string) Sink {
+	return &fileSink{path: path}
+}
+
+// StdoutSink is the Sink that writes result lines to standard output, which
+// is never closed. Sharding, several formats and rotation need files and do
+// not apply.
+func StdoutSink() Sink {
+	return &fileSink{path: "-"}
+}
+
+// WriterSink is the Sink that writes result lines to w, e.g. a bytes.Buffer
+// in a test or a caller's network stream; see WithOutputWriter.
+func WriterSink(w io.Writer) Sink {
+	return &fileSink{dest: w}
+}
+
+// fileSink is the Sink behind FileSink, StdoutSink and WriterSink. open
+// picks the lineWriter for the output settings: a lineSink for one stream,
+// or a shardSink or formatSink for several files.
+type fileSink struct {
+	path string
+	dest io.Writer
+	lineWriter
+}
+
+func (s *fileSink) open(_ context.Context, cfg outputConfig, logger *slog.Logger, workers int) error {
+	var (
+		w   lineWriter
+		err error
+	)
+	switch {
+	case s.dest != nil:
+		w = newLineSink(cfg, logger, s.dest)
+	case cfg.shards > 1:
+		key := func(r Result) int { return taskShard(r.TaskID, cfg.shards) }
+		label := func(k int) string { return fmt.Sprintf("shard-%d", k) }
+		// Every partition gets a file, even if it ends up empty.
+		w, err = openShards(cfg, logger, key, label, intRange(0, cfg.shards))
+	case cfg.shardBy == ShardWorker:
+		key := func(r Result) int { return r.WorkerID }
+		label := func(id int) string { return fmt.Sprintf("worker-%d", id) }
+		// Files for workers added by the autoscaler are created on first use.
+		w, err = openShards(cfg, logger, key, label, intRange(1, workers+1))
+	case len(cfg.formats) > 1:
+		w, err = openFormats(cfg.perFormat(), logger)
+	default:
+		w, err = openLineSink(cfg, logger)
+	}
+	if err != nil {
+		return err
+	}
+	s.lineWriter = w
+	return nil
+}
+
+// lineSink writes the result lines of one output stream: a file (or several
+// in turn when rotating), standard output when cfg.path is "-", or any
+// io.Writer, such as the one given to WithOutputWriter, which is written
+// like a file and closed at the end if it is an io.Closer. Like every Sink
+// it is only called from the writer goroutine, which guarantees:
+// - no interleaved writes
+// - no need for mutex locks around file output
+//
+// Each Result is serialized in cfg.format here, so workers never deal with
+// output layout. If cfg.gzip is set, the file is written as a gzip stream.
+// If cfg.batchSize is set, lines are written and flushed in batches, and
+// Flush writes out a partial one. If cfg.append is set, an existing file is
+// appended to instead of truncated.
+//
+// Every byte that reaches the file is also fed, through an io.MultiWriter,
+// to a SHA-256 hash, and outputFiles lists an OutputFile per file written.
+// The hash sits below the gzip layer, so it matches the file on disk.
+//
+// If cfg.rotateSize is set, the sink counts the (uncompressed) bytes of each
+// file and, once the current file holds at least that many, finishes it
+// (flush, gzip trailer, close) before the next line and continues in the
+// next numbered file. If cfg.rotateInterval is set, the first line once the
+// wall clock has passed the end of the current interval goes to a new file
+// named after its interval; an interval without results creates no file.
+// Rotation only happens between lines, so no line is ever split across
+// files.
+//
+// If cfg.checkpoint is set, the IDs of successful results are marked done
+// once their lines have been flushed to the file; sinkWriter saves it.
+//
+// Error handling:
+//   - A result that cannot be formatted is logged and skipped.
+//   - A write error that fatalWriteError accepts (e.g. ENOSPC, disk full) is
+//     fatal, and so is any write error under gzip, whose stream cannot be
+//     resumed: Write, Flush or Close returns it, the lines already flushed
+//     stay in the file and nothing more is written, so sinkWriter stops the
+//     run. The same goes for a rotated file that cannot be opened.
+//   - Any other write error only loses the lines buffered at the time: they
+//     are dropped, the buffer is reset and writing goes on. Close returns the
+//     first such error (later ones are logged), so an incomplete output file
+//     never goes unnoticed, and so are flush and close errors when finishing
+//     a file.
+type lineSink struct {
+	cfg    outputConfig
+	logger *slog.Logger
+
+	// err is the first recoverable error, returned by Close. fatal is set
+	// once the output failed for good; from then on nothing more is written.
+	err   error
+	fatal error
+
+	// The layers stacked on the current file; see start and finish. index
+	// numbers the files within period (the start of the rotation interval
+	// the current file belongs to).
+	index     int
+	period    time.Time
+	file      io.Writer
+	path      string
+	dw        *digestWriter
+	gz        *gzip.Writer
+	dst       io.Writer
+	buf       *bufio.Writer
+	fileBytes int64
+	fileLines int
+
+	// batch accumulates formatted lines so they reach the buffered writer as
+	// one block and are flushed together, instead of one tiny write per
+	// result. It is written out when it reaches cfg.batchSize lines or on
+	// Flush, which sinkWriter calls every batch interval, so a partial batch
+	// never sits in memory once the stream goes quiet.
+	//
+	// unflushed holds the IDs of successful results written since the last
+	// flush; they are handed to the checkpoint only once they reach the file.
+	batch     []byte
+	batched   int
+	sorted    sortedBatch
+	unflushed []int
+
+	outputs []OutputFile
+}
+
+// openLineSink opens the first output file with openOutput and returns the
+// lineSink writing to it.
+func openLineSink(cfg outputConfig, logger *slog.Logger) (*lineSink, error) {
+	file, err := openOutput(cfg, time.Now(), 1)
+	if err != nil {
+		return nil, err
+	}
+	return newLineSink(cfg, logger, file), nil
+}
+
+// newLineSink returns the lineSink writing to w, the first file (named after
+// w if it is an *os.File).
+func newLineSink(cfg outputConfig, logger *slog.Logger, w io.Writer) *lineSink {
+	s := &lineSink{cfg: cfg, logger: logger, index: 1}
+	if cfg.rotateInterval > 0 {
+		s.period = time.Now().Truncate(cfg.rotateInterval)
+	}
+	name := ""
+	if f, ok := w.(*os.File); ok {
+		name = f.Name()
+	}
+	s.start(w, name)
+	return s
+}
+
+// fail keeps the first recoverable error for Close; any later ones are only
+// logged so they are not lost.
+func (s *lineSink) fail(err error) {
+	if s.err == nil {
+		s.err = err
+		return
+	}
+	s.logger.Error(err.Error())
+}
+
+// writeFailed handles an error writing to the current file; see "Error
+// handling" above. bufio keeps returning the error it hit, so a recoverable
+// one is cleared by resetting the buffer, which drops the lines it held. It
+// reports whether writing can go on.
+func (s *lineSink) writeFailed(what string, err error) bool {
+	e := fmt.Errorf("failed to %s: %w", what, err)
+	if fatalWriteError(err) || s.gz != nil {
+		s.fatal = e
+		return false
+	}
+	s.fail(e)
+	s.logger.Warn("Output lines buffered at the time were lost; writing goes on")
+	s.buf.Reset(s.dst)
+	s.unflushed = s.unflushed[:0]
+	return true
+}
+
+func (s *lineSink) flushBatch() {
+	if s.fatal != nil || (s.batched == 0 && len(s.unflushed) == 0) {
+		return
+	}
+	ok := true
+	if s.batched > 0 {
+		if s.cfg.sortBatches {
+			s.batch = s.sorted.appendTo(s.batch)
+		}
+		if _, err := s.buf.Write(s.batch); err != nil {
+			ok = s.writeFailed("write output batch", err)
+		}
+	}
+	if err := s.buf.Flush(); ok && err != nil {
+		ok = s.writeFailed("flush output batch", err)
+	}
+	if ok && s.gz != nil && s.cfg.checkpoint != nil {
+		// Push compressed data out too, or checkpointed lines could still
+		// be sitting in the gzip writer.
+		if err := s.gz.Flush(); err != nil {
+			ok = s.writeFailed("flush gzip stream", err)
+		}
+	}
+	if ok && s.cfg.checkpoint != nil {
+		s.cfg.checkpoint.markDone(s.unflushed)
+	}
+	s.batch = s.batch[:0]
+	s.batched = 0
+	s.unflushed = s.unflushed[:0]
+}
+
+// start stacks the layers on f, the file at name (or "" for a writer that is
+// not a file): bufio.Writer -> gzip.Writer -> MultiWriter(f, digest). A nil
+// f (a rotated file that could not be opened) gets a buffer that discards
+// everything.
+func (s *lineSink) start(f io.Writer, name string) {
+	s.file, s.path = f, name
+	s.fileBytes, s.fileLines = 0, 0
+	s.gz = nil
+	if f == nil {
+		s.dw, s.dst = nil, io.Discard
+		s.buf = bufio.NewWriter(s.dst)
+		return
+	}
+
+	s.dw = &digestWriter{h: sha256.New()}
+	s.dst = io.MultiWriter(f, s.dw)
+	if s.cfg.gzip {
+		s.gz = gzip.NewWriter(s.dst)
+		s.dst = s.gz
+	}
+	s.buf = bufio.NewWriter(s.dst)
+	if s.cfg.writeBuffer >= minWriteBuffer {
+		s.buf = bufio.NewWriterSize(s.dst, s.cfg.writeBuffer)
+	}
+
+	// CSV files start with a header row, unless we are appending to a file
+	// that already has content (and therefore a header).
+	if osFile, ok := f.(*os.File); ok && s.cfg.append {
+		s.fileBytes = fileSize(osFile)
+	}
+	if s.cfg.format == FormatCSV && s.fileBytes == 0 {
+		header, err := formatCSV(csvHeader(s.cfg.verbose))
+		if err == nil {
+			_, err = s.buf.WriteString(header)
+		}
+		if err != nil {
+			s.writeFailed("write CSV header", err)
+		}
+		s.fileBytes += int64(len(header))
+	}
+}
+
+// finish shuts down the layers of the current file innermost first: write
+// out the pending batch, flush the buffer into the gzip stream, close the
+// gzip writer so its trailer is written (Flush alone leaves a corrupt
+// archive), and only then close the file. With dropEmpty, a file this run
+// created but wrote no result to is removed again. Once the output is
+// broken, the file is only closed: flushing would just fail again. A writer
+// that is not a file is closed if it is an io.Closer, but gets no
+// OutputFile.
+func (s *lineSink) finish(dropEmpty bool) {
+	s.flushBatch()
+	if err := s.buf.Flush(); s.fatal == nil && err != nil {
+		s.writeFailed("flush output buffer", err)
+	}
+	if s.gz != nil && s.fatal == nil {
+		if err := s.gz.Close(); err != nil {
+			s.fail(fmt.Errorf("failed to finish gzip stream: %w", err))
+		}
+	}
+	if s.file == nil || s.file == io.Writer(os.Stdout) {
+		return
+	}
+	if c, ok := s.file.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			s.fail(fmt.Errorf("failed to close output file: %w", err))
+		}
+	}
+	if s.path == "" {
+		return
+	}
+	if dropEmpty && s.fileLines == 0 && !s.cfg.append {
+		if err := os.Remove(s.path); err != nil {
+			s.logger.Warn(fmt.Sprintf("failed to remove empty output file: %v", err))
+		}
+		return
+	}
+	s.outputs = append(s.outputs, OutputFile{Path: s.path, Bytes: s.dw.n, SHA256: hex.EncodeToString(s.dw.h.Sum(nil))})
+}
+
+// rotate moves on to the next file: the next number in the same period, or
+// the first file of a new period.
+func (s *lineSink) rotate() {
+	// Only the first file can be empty here: it is opened before any result
+	// arrives, and the first line may come intervals later.
+	s.finish(true)
+	now := time.Now()
+	if s.cfg.rotateInterval > 0 && now.Truncate(s.cfg.rotateInterval).After(s.period) {
+		s.period, s.index = now.Truncate(s.cfg.rotateInterval), 0
+	}
+	s.index++
+	next, err := openOutput(s.cfg, now, s.index)
+	if err != nil {
+		s.fatal = err
+		s.start(nil, "")
+		return
+	}
+	s.start(next, s.cfg.filePath(now, s.index))
+	s.logger.Info(fmt.Sprintf("Rotated output to: %s", s.path), "path", s.path, "event", "ROTATED")
+}
+
+// rotateDue reports whether the next line goes to a new file.
+func (s *lineSink) rotateDue() bool {
+	if !s.cfg.rotates() {
+		return false
+	}
+	if s.cfg.rotateSize > 0 && s.fileBytes >= s.cfg.rotateSize {
+		return true
+	}
+	return s.cfg.rotateInterval > 0 && time.Now().Truncate(s.cfg.rotateInterval).After(s.period)
+}
+
+// Write formats r and adds its line to the current file, rotating first if
+// the file is full or its interval is over.
+func (s *lineSink) Write(r Result) error {
+	if s.fatal != nil {
+		return s.fatal
+	}
+	line, err := s.cfg.formatLine(r)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("failed to format result for Task-%d: %v", r.TaskID, err), "task", r.TaskID)
+		return nil
+	}
+	if s.rotateDue() {
+		if s.rotate(); s.fatal != nil {
+			return s.fatal
+		}
+	}
+	s.fileBytes += int64(len(line))
+	s.fileLines++
+	if s.cfg.checkpoint != nil && r.Err == nil {
+		s.unflushed = append(s.unflushed, r.TaskID)
+	}
+	if s.cfg.batchSize > 0 {
+		if s.cfg.sortBatches {
+			s.sorted.add(r.TaskID, line)
+		} else {
+			s.batch = append(s.batch, line...)
+		}
+		if s.batched++; s.batched >= s.cfg.batchSize {
+			s.flushBatch()
+		}
+		return s.fatal
+	}
+	if _, err := s.buf.WriteString(line); err != nil {
+		s.writeFailed("write output line", err)
+	}
+	return s.fatal
+}
+
+// Flush writes out the pending batch, if any.
+func (s *lineSink) Flush() error {
+	s.flushBatch()
+	return s.fatal
+}
+
+// Close finishes the current file. It returns the error that broke the
+// output if that happened only now, and the first recoverable error
+// otherwise.
+func (s *lineSink) Close() error {
+	broken := s.fatal != nil
+	s.finish(false)
+	if !broken && s.fatal != nil {
+		return errors.Join(s.fatal, s.err)
+	}
+	return s.err
+}
+
+func (s *lineSink) outputFiles() []OutputFile {
+	return s.outputs
+}
+
+// fileSize returns the size of f if it is a regular file, and 0 otherwise.
+func fileSize(f *os.File) int64 {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
+
+// collectErrors drains errorsChan, logging each task failure and counting
+// them in failures. If deadLetters is not nil, every failed task is also
+// forwarded to it, and it is closed once errorsChan is. onFailure, if not
+// nil, is called after each failure. done is closed last, after which
+// failures is safe to read.
+func collectErrors(logger *slog.Logger, errorsChan <-chan error, deadLetters chan<- *taskFailure, onFailure func(), failures *int, done chan<- struct{}) {
+	defer close(done)
+	if deadLetters != nil {
+		defer close(deadLetters)
+	}
+
+	for err := range errorsChan {
+		logger.Error(err.Error(), "event", "FAILED")
+		*failures++
+
+		var f *taskFailure
+		if deadLetters != nil && errors.As(err, &f) {
+			deadLetters <- f
+		}
+		if onFailure != nil {
+			onFailure()
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package workerpool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closeRecorder is an io.WriteCloser that records how often it was closed
+// and what it held at the time, so a test can tell that the writer flushed
+// before closing.
+type closeRecorder struct {
+	bytes.Buffer
+	closes  int
+	atClose string
+}
+
+func (c *closeRecorder) Close() error {
+	c.closes++
+	c.atClose = c.String()
+	return nil
+}
+
+// results returns n successful results in submission order.
+func results(n int) []Result {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	rs := make([]Result, n)
+	for i := range rs {
+		rs[i] = Result{TaskID: i + 1, WorkerID: 1, Payload: fmt.Sprintf("DATA-%d", i+1), Timestamp: ts, seq: i}
+	}
+	return rs
+}
+
+// runWriter feeds rs in that order to the writer goroutine's sinkWriter,
+// with a WriterSink for w, and returns the files written and the error.
+func runWriter(t *testing.T, cfg outputConfig, w io.Writer, rs []Result) ([]OutputFile, error) {
+	t.Helper()
+	ch := make(chan Result, len(rs))
+	for _, r := range rs {
+		ch <- r
+	}
+	close(ch)
+	sink := WriterSink(w).(builtinSink)
+	if err := sink.open(context.Background(), cfg, quietLogger(), 1); err != nil {
+		return nil, err
+	}
+	err := sinkWriter(context.Background(), cfg, quietLogger(), sink, ch)
+	return sink.outputFiles(), err
+}
+
+// wantLines formats rs in f, the way writer should have written them.
+func wantLines(t *testing.T, f Format, rs []Result) string {
+	t.Helper()
+	var sb strings.Builder
+	for _, r := range rs {
+		line, err := formatResult(f, false, r)
+		if err != nil {
+			t.Fatalf("formatResult() = %v", err)
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+func TestWriterToBuffer(t *testing.T) {
+	rs := results(5)
+	var buf bytes.Buffer
+	outputs, err := runWriter(t, outputConfig{format: FormatText}, &buf, rs)
+	if err != nil {
+		t.Fatalf("sinkWriter() = %v", err)
+	}
+	if got, want := buf.String(), wantLines(t, FormatText, rs); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if len(outputs) != 0 {
+		t.Errorf("outputs = %v, want none for a writer that is not a file", outputs)
+	}
+}
+
+func TestWriterClosesCloser(t *testing.T) {
+	rs := results(3)
+	var w closeRecorder
+	if _, err := runWriter(t, outputConfig{format: FormatCSV}, &w, rs); err != nil {
+		t.Fatalf("sinkWriter() = %v", err)
+	}
+	if w.closes != 1 {
+		t.Fatalf("Close called %d times, want 1", w.closes)
+	}
+	header, _ := formatCSV(csvHeader(false))
+	if want := header + wantLines(t, FormatCSV, rs); w.atClose != want {
+		t.Errorf("output at Close = %q, want %q", w.atClose, want)
+	}
+}
+
+func TestWriterGzip(t *testing.T) {
+	rs := results(20)
+	var buf bytes.Buffer
+	if _, err := runWriter(t, outputConfig{format: FormatJSON, gzip: true}, &buf, rs); err != nil {
+		t.Fatalf("sinkWriter() = %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() = %v", err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzip stream: %v", err)
+	}
+	if want := wantLines(t, FormatJSON, rs); string(got) != want {
+		t.Errorf("decompressed output = %q, want %q", got, want)
+	}
+}
+
+func TestWriterOrdered(t *testing.T) {
+	rs := results(6)
+	shuffled := []Result{rs[3], rs[0], rs[5], rs[2], rs[1], rs[4]}
+	var buf bytes.Buffer
+	if _, err := runWriter(t, outputConfig{format: FormatText, ordered: true}, &buf, shuffled); err != nil {
+		t.Fatalf("sinkWriter() = %v", err)
+	}
+	if got, want := buf.String(), wantLines(t, FormatText, rs); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+// BenchmarkWriter runs b.N tasks through four workers into an output file,
+// unbatched and with batches of several sizes, so ns/op is the cost of one
+// result from worker to disk. A batch of 1 flushes after every result.
+func BenchmarkWriter(b *testing.B) {
+	for _, size := range []int{0, 1, 100, 1000} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			p := NewPool[string](4, fastProcessor(),
+				WithOutputPath(filepath.Join(b.TempDir(), "out.txt")),
+				WithQueueSize(1024),
+				WithBatching(size, 500*time.Millisecond),
+				WithLogger(quietLogger()),
+			)
+			go func() {
+				defer p.Close()
+				for i := 1; i <= b.N; i++ {
+					p.Submit(StringTask{ID: i, Payload: fmt.Sprintf("data-%d", i)})
+				}
+			}()
+			b.ResetTimer()
+			if err := p.Run(context.Background()); err != nil {
+				b.Fatalf("Run() = %v", err)
+			}
+		})
+	}
+}
+
+// TestOutputWriterLifecycle runs a pool with WithOutputWriter: an io.Closer
+// is closed once, after every line reached it, and a plain io.Writer just
+// gets the lines.
+func TestOutputWriterLifecycle(t *testing.T) {
+	const n = 50
+	t.Run("closer", func(t *testing.T) {
+		var w closeRecorder
+		p := NewPool[string](4, fastProcessor(), WithOutputWriter(&w), WithLogger(quietLogger()))
+		submitAll(p, n)
+		if err := runWithin(t, p, 10*time.Second); err != nil {
+			t.Fatalf("Run() = %v", err)
+		}
+		if w.closes != 1 {
+			t.Fatalf("Close called %d times, want 1", w.closes)
+		}
+		if got := strings.Count(w.atClose, "\n"); got != n {
+			t.Errorf("%d lines written before Close, want %d", got, n)
+		}
+	})
+	t.Run("writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		p := NewPool[string](4, fastProcessor(), WithOutputWriter(&buf), WithLogger(quietLogger()))
+		submitAll(p, n)
+		if err := runWithin(t, p, 10*time.Second); err != nil {
+			t.Fatalf("Run() = %v", err)
+		}
+		if got := strings.Count(buf.String(), "\n"); got != n {
+			t.Errorf("got %d output lines, want %d", got, n)
+		}
+	})
+}
+
+// TestOutputWriterClosedWhenRunFails checks that Run closes the writer even
+// when it fails before starting the writer goroutine.
+func TestOutputWriterClosedWhenRunFails(t *testing.T) {
+	var w closeRecorder
+	p := NewPool[string](1, fastProcessor(),
+		WithOutputWriter(&w),
+		WithDeadLetter(filepath.Join(t.TempDir(), "missing", "dead.jsonl")),
+		WithLogger(quietLogger()),
+	)
+	p.Close()
+	if err := runWithin(t, p, 10*time.Second); err == nil {
+		t.Fatal("Run() = nil, want an error for the dead-letter file")
+	}
+	if w.closes != 1 {
+		t.Errorf("Close called %d times, want 1", w.closes)
+	}
+}